@@ -0,0 +1,90 @@
+package jj
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// parseAnnotations reads an annotation document, which is a JSON object that
+// maps keypaths to the comment that should be written above them.
+func parseAnnotations(data []byte) (map[string]string, error) {
+	doc := gjson.ParseBytes(data)
+	if !doc.IsObject() {
+		return nil, errors.New("annotations must be a JSON object")
+	}
+	notes := make(map[string]string)
+	var err error
+	doc.ForEach(func(k, v gjson.Result) bool {
+		if v.Type != gjson.String {
+			err = errors.New("annotation for \"" + k.String() +
+				"\" is not a string")
+			return false
+		}
+		notes[k.String()] = v.Str
+		return true
+	})
+	return notes, err
+}
+
+// annotate renders json as indented JSONC, writing the comment for each
+// annotated keypath on the lines above its member or element.
+func annotate(json []byte, notes map[string]string) []byte {
+	buf := appendAnnotated(nil, gjson.ParseBytes(json), "", 0, notes)
+	return append(buf, '\n')
+}
+
+func appendAnnotated(buf []byte, v gjson.Result, path string, depth int,
+	notes map[string]string) []byte {
+	if !v.IsObject() && !v.IsArray() {
+		return append(buf, strings.TrimSpace(v.Raw)...)
+	}
+	open, close := byte('['), byte(']')
+	if v.IsObject() {
+		open, close = '{', '}'
+	}
+	buf = append(buf, open)
+	i := 0
+	v.ForEach(func(k, e gjson.Result) bool {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '\n')
+		var cpath string
+		if v.IsObject() {
+			cpath = joinPath(path, escapeKey(k.String()))
+		} else {
+			cpath = joinPath(path, strconv.Itoa(i))
+		}
+		if note, ok := notes[cpath]; ok {
+			for _, line := range strings.Split(note, "\n") {
+				buf = appendIndent(buf, depth+1)
+				buf = append(buf, "// "...)
+				buf = append(buf, line...)
+				buf = append(buf, '\n')
+			}
+		}
+		buf = appendIndent(buf, depth+1)
+		if v.IsObject() {
+			buf = append(buf, k.Raw...)
+			buf = append(buf, ": "...)
+		}
+		buf = appendAnnotated(buf, e, cpath, depth+1, notes)
+		i++
+		return true
+	})
+	if i > 0 {
+		buf = append(buf, '\n')
+		buf = appendIndent(buf, depth)
+	}
+	return append(buf, close)
+}
+
+func appendIndent(buf []byte, depth int) []byte {
+	for i := 0; i < depth; i++ {
+		buf = append(buf, "  "...)
+	}
+	return buf
+}
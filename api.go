@@ -0,0 +1,140 @@
+package jj
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// Options control how values are set and how results are formatted by the
+// library functions.
+type Options struct {
+	Raw        bool // set and get values as raw JSON, like -r
+	Pretty     bool // make the result pretty, like -p
+	Ugly       bool // make the result ugly, like -u
+	Lines      bool // write array values on separate lines, like -l
+	Optimistic bool // optimistically update values in place, like -O
+
+	// Template, when set, is the Go template that the result is rendered
+	// with, like --template.
+	Template string
+
+	// The pretty printing options, as set with --indent, --width, --prefix,
+	// and -S. Setting any of them implies Pretty unless Ugly is set.
+	Indent   string // whitespace to indent with, two spaces by default
+	Width    int    // maximum width of single line arrays, 80 by default
+	Prefix   string // string that starts every line
+	SortKeys bool   // sort the keys of every object
+}
+
+// Config describes a single jj operation for RunConfig. The value at
+// Keypath is read unless Value is set, or Delete is true.
+type Config struct {
+	Options
+	Keypath string
+	Value   *string
+	Delete  bool
+}
+
+func (o Options) args() args {
+	a := newArgs()
+	a.raw = o.Raw
+	a.pretty = o.Pretty
+	a.ugly = o.Ugly
+	a.lines = o.Lines
+	a.opt = o.Optimistic
+	a.notty = true
+	if o.Indent != "" {
+		a.prettyOptions().Indent = o.Indent
+	}
+	if o.Width != 0 {
+		a.prettyOptions().Width = o.Width
+	}
+	if o.Prefix != "" {
+		a.prettyOptions().Prefix = o.Prefix
+	}
+	if o.SortKeys {
+		a.prettyOptions().SortKeys = true
+	}
+	if a.popts != nil && !a.ugly {
+		a.pretty = true
+	}
+	return a
+}
+
+func (cfg Config) args() (args, error) {
+	a := cfg.Options.args()
+	if cfg.Template != "" {
+		t, err := parseTemplate(cfg.Template)
+		if err != nil {
+			return a, err
+		}
+		a.tmpl = t
+	}
+	if cfg.Keypath != "" {
+		a.keypathok = true
+		a.keypath = cfg.Keypath
+	}
+	if cfg.Value != nil && cfg.Delete {
+		return a, errors.New("cannot set and delete a value at once")
+	}
+	if (cfg.Value != nil || cfg.Delete) && cfg.Keypath == "" {
+		return a, errors.New("missing keypath")
+	}
+	if cfg.Value != nil {
+		a.edits = []edit{{keypath: cfg.Keypath, value: *cfg.Value,
+			raw: cfg.Raw}}
+		a.keypathok = false
+	}
+	if cfg.Delete {
+		a.edits = []edit{{keypath: cfg.Keypath, del: true}}
+		a.del = true
+		a.keypathok = false
+	}
+	return a, nil
+}
+
+// Get returns the value at keypath in the input, formatted like jj outputs
+// it, or the whole input when keypath is empty.
+func Get(input []byte, keypath string, opts Options) ([]byte, error) {
+	return apply(Config{Options: opts, Keypath: keypath}, input)
+}
+
+// Set returns the input with the value at keypath replaced by value. The
+// type of value is detected like with -v, unless opts.Raw is set. With
+// opts.Optimistic, the input may be modified.
+func Set(input []byte, keypath, value string, opts Options) ([]byte, error) {
+	return apply(Config{Options: opts, Keypath: keypath, Value: &value}, input)
+}
+
+// Delete returns the input with the value at keypath removed.
+func Delete(input []byte, keypath string, opts Options) ([]byte, error) {
+	return apply(Config{Options: opts, Keypath: keypath, Delete: true}, input)
+}
+
+func apply(cfg Config, input []byte) ([]byte, error) {
+	a, err := cfg.args()
+	if err != nil {
+		return nil, err
+	}
+	res, err := process(context.Background(), a, input)
+	if err != nil {
+		return nil, err
+	}
+	if res, err = applyTemplate(a, res); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(format(a, res, nil, false), []byte{'\n'}), nil
+}
+
+// RunConfig performs the operation in cfg on the document read from in,
+// writing the result to out like the jj command does.
+func RunConfig(cfg Config, in io.Reader, out io.Writer) error {
+	a, err := cfg.args()
+	if err != nil {
+		return err
+	}
+	_, err = execute(context.Background(), a, in, out, io.Discard)
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package jj
+
+import "os"
+
+// chownLike does nothing on systems without Unix file ownership.
+func chownLike(f *os.File, fi os.FileInfo) {}
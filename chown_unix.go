@@ -0,0 +1,17 @@
+//go:build unix
+
+package jj
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike gives f the owner and group of the file described by fi, when
+// the process is allowed to. Failures are ignored, leaving the file owned by
+// the user running jj.
+func chownLike(f *os.File, fi os.FileInfo) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		f.Chown(int(st.Uid), int(st.Gid))
+	}
+}
@@ -0,0 +1,110 @@
+package jj
+
+import (
+	"strconv"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+)
+
+// compareWriter writes the differences between two documents for the cmp
+// command, one line for each value that was removed, added, or changed,
+// with its keypath.
+type compareWriter struct {
+	buf   []byte
+	color bool
+}
+
+func (w *compareWriter) line(kind byte, path string, a, b *gjson.Result) {
+	if path == "" {
+		path = "@this"
+	}
+	if w.color {
+		code := "33"
+		switch kind {
+		case '-':
+			code = "31"
+		case '+':
+			code = "32"
+		}
+		w.buf = append(w.buf, "\x1B["+code+"m"...)
+	}
+	w.buf = append(w.buf, kind, ' ')
+	w.buf = append(w.buf, path...)
+	w.buf = append(w.buf, ": "...)
+	if a != nil {
+		w.buf = append(w.buf, pretty.Ugly([]byte(a.Raw))...)
+	}
+	if a != nil && b != nil {
+		w.buf = append(w.buf, " -> "...)
+	}
+	if b != nil {
+		w.buf = append(w.buf, pretty.Ugly([]byte(b.Raw))...)
+	}
+	if w.color {
+		w.buf = append(w.buf, "\x1B[0m"...)
+	}
+	w.buf = append(w.buf, '\n')
+}
+
+func (w *compareWriter) compare(a, b gjson.Result, path string) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch {
+	case a.IsObject() && b.IsObject():
+		am := a.Map()
+		a.ForEach(func(k, av gjson.Result) bool {
+			p := join(escapeKey(k.String()))
+			if bv := b.Get(escapeKey(k.String())); !bv.Exists() {
+				w.line('-', p, &av, nil)
+			} else {
+				w.compare(av, bv, p)
+			}
+			return true
+		})
+		b.ForEach(func(k, bv gjson.Result) bool {
+			if _, ok := am[k.String()]; !ok {
+				w.line('+', join(escapeKey(k.String())), nil, &bv)
+			}
+			return true
+		})
+	case a.IsArray() && b.IsArray():
+		aa, ba := a.Array(), b.Array()
+		for i := 0; i < len(aa) && i < len(ba); i++ {
+			w.compare(aa[i], ba[i], join(strconv.Itoa(i)))
+		}
+		for i := len(ba); i < len(aa); i++ {
+			w.line('-', join(strconv.Itoa(i)), &aa[i], nil)
+		}
+		for i := len(aa); i < len(ba); i++ {
+			w.line('+', join(strconv.Itoa(i)), nil, &ba[i])
+		}
+	case !a.Exists() && b.Exists():
+		w.line('+', path, nil, &b)
+	case a.Exists() && !b.Exists():
+		w.line('-', path, &a, nil)
+	default:
+		if !jsonEqual(a, b) {
+			w.line('~', path, &a, &b)
+		}
+	}
+}
+
+// compareDocuments returns the differences between the values at keypath in
+// the documents a and b, ignoring the order of object members and the way
+// the values are written, or nothing when they are equal. Each line is the
+// keypath of a value removed from a with -, added in b with +, or changed
+// with ~, like `~ name.first: "Tom" -> "Thomas"`.
+func compareDocuments(a, b []byte, keypath string, color bool) []byte {
+	va, vb := gjson.ParseBytes(a), gjson.ParseBytes(b)
+	if keypath != "" {
+		va, vb = gjson.GetBytes(a, keypath), gjson.GetBytes(b, keypath)
+	}
+	w := compareWriter{color: color}
+	w.compare(va, vb, keypath)
+	return w.buf
+}
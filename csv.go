@@ -0,0 +1,58 @@
+package jj
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+)
+
+// jsonToCSV converts a JSON array of objects to CSV with the fields
+// separated by comma. The header row has the union of the object keys, in
+// the order they are first seen. Missing values and null are empty fields,
+// and nested objects and arrays are written as JSON.
+func jsonToCSV(json []byte, comma rune) ([]byte, error) {
+	doc := gjson.ParseBytes(json)
+	if !doc.IsArray() {
+		return nil, errors.New("csv output requires an array of objects")
+	}
+	rows := doc.Array()
+	var header []string
+	index := make(map[string]int)
+	for _, row := range rows {
+		if !row.IsObject() {
+			return nil, errors.New("csv output requires an array of objects")
+		}
+		row.ForEach(func(k, _ gjson.Result) bool {
+			if _, ok := index[k.Str]; !ok {
+				index[k.Str] = len(header)
+				header = append(header, k.Str)
+			}
+			return true
+		})
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = comma
+	w.Write(header)
+	for _, row := range rows {
+		record := make([]string, len(header))
+		row.ForEach(func(k, v gjson.Result) bool {
+			switch v.Type {
+			case gjson.Null:
+			case gjson.String:
+				record[index[k.Str]] = v.Str
+			case gjson.JSON:
+				record[index[k.Str]] = string(pretty.Ugly([]byte(v.Raw)))
+			default:
+				record[index[k.Str]] = v.Raw
+			}
+			return true
+		})
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
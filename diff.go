@@ -0,0 +1,162 @@
+package jj
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffOp is a line of a diff, kept (' '), removed ('-'), or added ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines finds the shortest edit script that turns the lines of a into
+// the lines of b, using the Myers algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	var x, y int
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+	var ops []diffOp
+	x, y = n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := v[max+pk]
+		py := px - pk
+		for x > px && y > py {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == px {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = px, py
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// unifiedDiff returns the differences between the lines of before and after
+// in the unified format, with three lines of context, optionally colored.
+func unifiedDiff(before, after []byte, from, to string, color bool) []byte {
+	const context = 3
+	ops := diffLines(splitLines(before), splitLines(after))
+	// the line numbers in before and after where each op starts
+	posa := make([]int, len(ops)+1)
+	posb := make([]int, len(ops)+1)
+	for i, op := range ops {
+		posa[i+1], posb[i+1] = posa[i], posb[i]
+		if op.kind != '+' {
+			posa[i+1]++
+		}
+		if op.kind != '-' {
+			posb[i+1]++
+		}
+	}
+	paint := func(buf []byte, code, line string) []byte {
+		if color {
+			return append(append(append(buf, "\x1B["+code+"m"...), line...),
+				"\x1B[0m\n"...)
+		}
+		return append(append(buf, line...), '\n')
+	}
+	var buf []byte
+	buf = paint(buf, "1", "--- "+from)
+	buf = paint(buf, "1", "+++ "+to)
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			j := end
+			for j < len(ops) && ops[j].kind == ' ' {
+				j++
+			}
+			if j == len(ops) || j-end > 2*context {
+				end += context
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = j
+		}
+		na, nb := posa[end]-posa[start], posb[end]-posb[start]
+		sa, sb := posa[start], posb[start]
+		if na > 0 {
+			sa++
+		}
+		if nb > 0 {
+			sb++
+		}
+		buf = paint(buf, "36", fmt.Sprintf("@@ -%d,%d +%d,%d @@", sa, na, sb, nb))
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case '-':
+				buf = paint(buf, "31", "-"+op.line)
+			case '+':
+				buf = paint(buf, "32", "+"+op.line)
+			default:
+				buf = append(append(append(buf, ' '), op.line...), '\n')
+			}
+		}
+		i = end
+	}
+	return buf
+}
@@ -0,0 +1,120 @@
+package jj
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// eachResult is the outcome of the operation on one of the --each files.
+type eachResult struct {
+	out, errOut bytes.Buffer
+	code        int
+	err         error
+	done        chan struct{}
+}
+
+// each performs the operation on every file that matches the --each
+// pattern, with up to --jobs files at once. The outputs are written in the
+// order of the files, each as soon as the files before it are done, and a
+// failure is reported with the name of its file without stopping the
+// others. The exit code is the highest of the files. Once ctx is done, the
+// files not started yet are skipped and the operation fails.
+func each(ctx context.Context, a args, out, errOut io.Writer) (int, error) {
+	names, err := filepath.Glob(*a.each)
+	if err != nil {
+		return exitUsage, err
+	}
+	if len(names) == 0 {
+		return exitIO, fmt.Errorf("no files match %q", *a.each)
+	}
+	// modifiers can't be registered concurrently, so they are registered
+	// once here rather than for each file
+	registerModifiers(a.modifiers, errOut)
+	jobs := a.jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if len(a.modifiers) > 0 {
+		// the failure of a modifier command is only known to belong to
+		// its file when the files are processed one at a time
+		jobs = 1
+	}
+	a.modifiers = nil
+	results := make([]*eachResult, len(names))
+	for i := range results {
+		results[i] = &eachResult{done: make(chan struct{})}
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < len(names); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				r := results[i]
+				if ctx.Err() == nil {
+					fa := eachArgs(a, names[i])
+					r.code, r.err = execute(ctx, fa, strings.NewReader(""),
+						&r.out, &r.errOut)
+				}
+				close(r.done)
+			}
+		}()
+	}
+	go func() {
+		for i := range names {
+			work <- i
+		}
+		close(work)
+	}()
+	code := 0
+	var werr error
+	for i, r := range results {
+		<-r.done
+		if ctx.Err() != nil {
+			// the files that timed out are not reported one by one
+			wg.Wait()
+			return timedOut(a)
+		}
+		if werr == nil {
+			_, werr = out.Write(r.out.Bytes())
+		}
+		errOut.Write(r.errOut.Bytes())
+		if r.err != nil {
+			reportError(errOut, fmt.Errorf("%s: %w", names[i], r.err), r.code)
+		}
+		if r.code > code {
+			code = r.code
+		}
+	}
+	wg.Wait()
+	if werr != nil {
+		return exitIO, werr
+	}
+	return code, nil
+}
+
+// eachArgs returns the arguments of the operation on one of the --each
+// files, which is its input, and its output with -I. The format of each
+// file is the one of its extension unless --from or --to are given.
+func eachArgs(a args, name string) args {
+	a.infiles = []string{name}
+	if a.inplace {
+		a.outfile = &a.infiles[0]
+	}
+	if a.from == "" {
+		a.from = formatOf(name)
+	}
+	if a.to == "" && a.outfile != nil {
+		a.to = formatOf(name)
+	}
+	// the values of -V are read into the edits, which are not shared
+	a.edits = append([]edit(nil), a.edits...)
+	return a
+}
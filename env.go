@@ -0,0 +1,106 @@
+package jj
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+)
+
+// envSubst replaces the ${VAR} references in data with the values of the
+// environment variables, or with the default of ${VAR:-default} when the
+// variable is unset or empty. The values are escaped like the contents of a
+// JSON string, as that's where references are usually found.
+func envSubst(data []byte) []byte {
+	var out []byte
+	for {
+		i := bytes.Index(data, []byte("${"))
+		if i < 0 {
+			return append(out, data...)
+		}
+		j := bytes.IndexByte(data[i+2:], '}')
+		if j < 0 {
+			return append(out, data...)
+		}
+		ref := string(data[i+2 : i+2+j])
+		name, def, hasDef := strings.Cut(ref, ":-")
+		if !validEnvName(name) {
+			out = append(out, data[:i+2]...)
+			data = data[i+2:]
+			continue
+		}
+		val := os.Getenv(name)
+		if val == "" && hasDef {
+			val = def
+		}
+		quoted := appendJSONString(nil, val)
+		out = append(out, data[:i]...)
+		out = append(out, quoted[1:len(quoted)-1]...)
+		data = data[i+3+j:]
+	}
+}
+
+func validEnvName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' ||
+			(i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// jsonToExport converts a JSON object to shell lines, one
+// "export KEY='value'" for each member. Characters of a key that can't be
+// in a variable name are replaced with underscores. Strings are written
+// without quotes, null as an empty value, and objects and arrays as JSON.
+func jsonToExport(json []byte) ([]byte, error) {
+	doc := gjson.ParseBytes(json)
+	if !doc.IsObject() {
+		return nil, errors.New("--export requires an object")
+	}
+	var out []byte
+	doc.ForEach(func(k, v gjson.Result) bool {
+		out = append(out, "export "...)
+		out = append(out, envName(k.String())...)
+		out = append(out, '=')
+		var val string
+		switch v.Type {
+		case gjson.Null:
+		case gjson.String:
+			val = v.Str
+		case gjson.JSON:
+			val = string(pretty.Ugly([]byte(v.Raw)))
+		default:
+			val = v.Raw
+		}
+		out = append(out, shellQuote(val)...)
+		out = append(out, '\n')
+		return true
+	})
+	return out, nil
+}
+
+// envName makes key a valid environment variable name.
+func envName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' ||
+			c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+		b = append([]byte{'_'}, b...)
+	}
+	return string(b)
+}
+
+// shellQuote quotes s with single quotes for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,50 @@
+package jj
+
+import "github.com/tidwall/gjson"
+
+// jsonEqual reports whether two JSON values are deeply equal, ignoring the
+// order of object members, insignificant whitespace and the way numbers
+// are written.
+func jsonEqual(a, b gjson.Result) bool {
+	if a.Exists() != b.Exists() {
+		return false
+	}
+	if a.IsObject() != b.IsObject() || a.IsArray() != b.IsArray() {
+		return false
+	}
+	switch {
+	case a.IsObject():
+		am, bm := a.Map(), b.Map()
+		if len(am) != len(bm) {
+			return false
+		}
+		for k, av := range am {
+			bv, ok := bm[k]
+			if !ok || !jsonEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	case a.IsArray():
+		aa, ba := a.Array(), b.Array()
+		if len(aa) != len(ba) {
+			return false
+		}
+		for i := range aa {
+			if !jsonEqual(aa[i], ba[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case gjson.Number:
+		return a.Num == b.Num
+	case gjson.String:
+		return a.Str == b.Str
+	}
+	return true
+}
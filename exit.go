@@ -0,0 +1,113 @@
+package jj
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+)
+
+// The exit codes, besides 0 on success and 1 when the keypath is missing
+// with -e or --exists, there is nothing to delete, --find-value finds nothing,
+// or a check fails.
+const (
+	exitUsage  = 2 // the command line is not valid
+	exitIO     = 3 // a file or URL could not be read or written
+	exitSyntax = 4 // the input is not valid JSON, YAML, or TOML
+	exitError  = 5 // any other failure, like an edit that can't be made
+
+	// exitTimeout is the exit code used when --timeout expires, matching
+	// the convention of timeout(1).
+	exitTimeout = 124
+)
+
+// syntaxError is an error in the syntax of an input document.
+type syntaxError struct{ err error }
+
+func (e *syntaxError) Error() string { return e.err.Error() }
+func (e *syntaxError) Unwrap() error { return e.err }
+
+// invalidInput marks err, if any, as an error in the syntax of the input.
+func invalidInput(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &syntaxError{err}
+}
+
+// exitCode returns the exit code for the failure err.
+func exitCode(err error) int {
+	var serr *syntaxError
+	var perr *fs.PathError
+	var lerr *os.LinkError
+	var scerr *os.SyscallError
+	var uerr *url.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.As(err, &serr):
+		return exitSyntax
+	case errors.As(err, &perr), errors.As(err, &lerr), errors.As(err, &scerr),
+		errors.As(err, &uerr):
+		return exitIO
+	}
+	return exitError
+}
+
+// errorKinds names the exit codes in the errors written by
+// --error-format json.
+var errorKinds = map[int]string{
+	exitUsage:   "usage",
+	exitIO:      "io",
+	exitSyntax:  "syntax",
+	exitError:   "error",
+	exitTimeout: "timeout",
+}
+
+// usageReporter is an error output that reports the errors in the command
+// line by itself, instead of with the usage text.
+type usageReporter interface {
+	usageError(msg string)
+}
+
+// jsonErrors is the error output of --error-format json. The failures are
+// written to it as JSON objects, while warnings are written as they are.
+type jsonErrors struct{ io.Writer }
+
+func (w *jsonErrors) usageError(msg string) {
+	writeJSONError(w.Writer, msg, exitUsage)
+}
+
+// writeJSONError writes the failure msg with its exit code to w as a JSON
+// object on a line of its own, like {"error":"...","kind":"io","code":3}.
+func writeJSONError(w io.Writer, msg string, code int) {
+	buf := appendJSONString([]byte(`{"error":`), msg)
+	buf = append(buf, `,"kind":`...)
+	buf = appendJSONString(buf, errorKinds[code])
+	buf = append(buf, fmt.Sprintf(`,"code":%d}`+"\n", code)...)
+	w.Write(buf)
+}
+
+// reportError writes the failure err to w, as a JSON object when w is the
+// error output of --error-format json.
+func reportError(w io.Writer, err error, code int) {
+	if jw, ok := w.(*jsonErrors); ok {
+		writeJSONError(jw.Writer, err.Error(), code)
+		return
+	}
+	fmt.Fprintf(w, "error: %v\n", err)
+}
+
+// errorFormat returns the value of --error-format in argv, which is needed
+// before the arguments are parsed to report the errors in them.
+func errorFormat(argv []string) string {
+	for i := 0; i+1 < len(argv); i++ {
+		if argv[i] == "--error-format" {
+			return argv[i+1]
+		}
+	}
+	return "text"
+}
@@ -0,0 +1,198 @@
+package jj
+
+import (
+	"fmt"
+	"strings"
+)
+
+// querySegment is a single stage of a parsed gjson keypath.
+type querySegment struct {
+	text string // segment as written in the keypath
+	sep  byte   // separator that preceded it, '.' or '|', 0 for the first
+	kind string
+	desc string
+}
+
+// scanBalanced returns the index just past the bracket that closes the one
+// at path[i], skipping over string literals.
+func scanBalanced(path string, i int) int {
+	depth := 0
+	for ; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '"':
+			for i++; i < len(path) && path[i] != '"'; i++ {
+				if path[i] == '\\' {
+					i++
+				}
+			}
+		case '[', '{', '(':
+			depth++
+		case ']', '}', ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(path)
+}
+
+// splitQuery splits a keypath into its segments on the unescaped '.' and
+// '|' separators that are not inside queries, multipaths, or modifier
+// arguments.
+func splitQuery(path string) []querySegment {
+	var segs []querySegment
+	var sep byte
+	start := 0
+	for i := 0; i < len(path); {
+		switch c := path[i]; {
+		case c == '\\':
+			i += 2
+		case c == '(' || c == '[' || c == '{':
+			i = scanBalanced(path, i)
+		case c == '"':
+			i = scanBalanced(path, i)
+		case c == '.' || c == '|':
+			segs = append(segs, querySegment{text: path[start:i], sep: sep})
+			sep = c
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	return append(segs, querySegment{text: path[start:], sep: sep})
+}
+
+func unescapeKey(key string) string {
+	if !strings.Contains(key, "\\") {
+		return key
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		if key[i] == '\\' && i+1 < len(key) {
+			i++
+		}
+		b.WriteByte(key[i])
+	}
+	return b.String()
+}
+
+func hasWildcard(key string) bool {
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '\\':
+			i++
+		case '*', '?':
+			return true
+		}
+	}
+	return false
+}
+
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// explainQuery describes how gjson interprets each segment of path.
+func explainQuery(path string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "keypath: %s\n", path)
+	rest := path
+	if strings.HasPrefix(rest, "..") {
+		fmt.Fprintf(&b, "  the \"..\" prefix treats the input as JSON Lines, "+
+			"an array with one element per line\n")
+		rest = rest[2:]
+	}
+	if rest == "" {
+		return b.String()
+	}
+	segs := splitQuery(rest)
+	mapped := false
+	for i := range segs {
+		s := &segs[i]
+		last := i == len(segs)-1
+		if s.sep == '|' {
+			mapped = false
+		}
+		switch t := s.text; {
+		case t == "":
+			s.kind, s.desc = "empty", "matches nothing"
+		case t[0] == '@':
+			name, arg := t[1:], ""
+			if j := strings.IndexByte(name, ':'); j >= 0 {
+				name, arg = name[:j], name[j+1:]
+			}
+			s.kind = "modifier"
+			s.desc = fmt.Sprintf("result of the @%s modifier", name)
+			if arg != "" {
+				s.desc += fmt.Sprintf(" with the argument %s", arg)
+			}
+		case t[0] == '!':
+			s.kind, s.desc = "literal", fmt.Sprintf("the JSON value %s", t[1:])
+		case t[0] == '[':
+			s.kind = "multipath"
+			s.desc = "new array built from the comma separated keypaths"
+		case t[0] == '{':
+			s.kind = "multipath"
+			s.desc = "new object built from the comma separated keypaths"
+		case t == "#" && last:
+			s.kind, s.desc = "count", "number of elements in the array"
+		case t == "#":
+			s.kind = "map"
+			s.desc = "every element of the array, the following segments " +
+				"are applied to each element"
+			mapped = true
+		case strings.HasPrefix(t, "#(") && strings.HasSuffix(t, ")#"):
+			s.kind = "query"
+			s.desc = fmt.Sprintf("array of all the elements matching %s",
+				t[2:len(t)-2])
+			mapped = true
+		case strings.HasPrefix(t, "#("):
+			s.kind = "query"
+			s.desc = fmt.Sprintf("first element matching %s", t[2:len(t)-1])
+		case hasWildcard(t):
+			s.kind = "wildcard"
+			s.desc = fmt.Sprintf("first member whose key matches %q", t)
+		case isIndex(t):
+			s.kind = "index"
+			s.desc = fmt.Sprintf("element %s of an array, or the member "+
+				"%q of an object", t, t)
+		default:
+			s.kind = "key"
+			s.desc = fmt.Sprintf("value of the member %q", unescapeKey(t))
+		}
+		if mapped && s.kind != "map" && s.kind != "query" {
+			s.desc += ", for each element"
+		}
+	}
+	width := 0
+	for _, s := range segs {
+		if len(s.text)+1 > width {
+			width = len(s.text) + 1
+		}
+	}
+	for i, s := range segs {
+		text := s.text
+		if s.sep != 0 {
+			text = string(s.sep) + text
+		}
+		fmt.Fprintf(&b, "  %2d. %-*s  %-9s  %s\n", i+1, width, text,
+			s.kind, s.desc)
+	}
+	if strings.Contains(rest, "|") {
+		fmt.Fprintf(&b, "  a \"|\" applies the next segment to the whole "+
+			"result so far, instead of to each element\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package jj
+
+import (
+	"bytes"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/match"
+	"github.com/tidwall/pretty"
+)
+
+// valueMatcher returns a function that reports whether a JSON value equals
+// val, which is interpreted with the same type detection used by -v.
+func valueMatcher(val string, raw bool) func(v gjson.Result) bool {
+	if !raw && !autoRaw(val) {
+		return func(v gjson.Result) bool {
+			return v.Type == gjson.String && v.Str == val
+		}
+	}
+	want := gjson.Parse(val)
+	ugly := pretty.Ugly([]byte(val))
+	return func(v gjson.Result) bool {
+		if v.Type != want.Type {
+			return false
+		}
+		switch v.Type {
+		case gjson.Number:
+			return v.Num == want.Num
+		case gjson.String:
+			return v.Str == want.Str
+		case gjson.JSON:
+			return bytes.Equal(pretty.Ugly([]byte(v.Raw)), ugly)
+		}
+		return true
+	}
+}
+
+// findValue returns the keypaths beneath root whose value matches, stopping
+// after the first one unless all is set.
+func findValue(root gjson.Result, path string, match func(gjson.Result) bool,
+	all bool) []string {
+	var paths []string
+	walk(root, path, func(p string, v gjson.Result) bool {
+		if match(v) {
+			paths = append(paths, p)
+			return all
+		}
+		return true
+	})
+	return paths
+}
+
+// findKey returns a JSON array with the keypath and value of every object
+// member beneath root whose key matches the pattern, where * matches any
+// characters and ? a single one.
+func findKey(root gjson.Result, path, pattern, order string) []byte {
+	buf := []byte{'['}
+	n := 0
+	members := func(p string, v gjson.Result) bool {
+		if !v.IsObject() {
+			return true
+		}
+		v.ForEach(func(k, e gjson.Result) bool {
+			if !match.Match(k.String(), pattern) {
+				return true
+			}
+			if n > 0 {
+				buf = append(buf, ',')
+			}
+			n++
+			buf = append(buf, `{"path":`...)
+			buf = appendJSONString(buf, joinPath(p, escapeKey(k.String())))
+			buf = append(buf, `,"value":`...)
+			buf = append(buf, e.Raw...)
+			buf = append(buf, '}')
+			return true
+		})
+		return true
+	}
+	members(path, root)
+	walkOrder(root, path, order, members)
+	return append(buf, ']')
+}
@@ -3,14 +3,14 @@ module github.com/nuvolaris/jj
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/mattn/go-isatty v0.0.14
 	github.com/tidwall/gjson v1.14.0
+	github.com/tidwall/match v1.1.1
 	github.com/tidwall/pretty v1.2.0
 	github.com/tidwall/sjson v1.2.4
-
+	golang.org/x/term v0.1.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/tidwall/match v1.1.1 // indirect
-	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
-)
+require golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
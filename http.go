@@ -0,0 +1,153 @@
+package jj
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// tokenEnv is the environment variable with the bearer token sent to https
+// URLs, unless an Authorization header is given with --header.
+const tokenEnv = "JJ_TOKEN"
+
+// tokenHTTPEnv is the environment variable that, set to 1, sends the token
+// to plain http URLs too, where anyone on the way can read it.
+const tokenHTTPEnv = "JJ_TOKEN_HTTP"
+
+// httpClient sends the requests to http(s) URLs. Its timeout keeps a server
+// that stops responding from hanging jj when no --timeout is given.
+var httpClient = &http.Client{Timeout: 5 * time.Minute,
+	CheckRedirect: checkRedirect}
+
+// sendToken reports whether the JJ_TOKEN token may be sent to u.
+func sendToken(u *url.URL) bool {
+	return u.Scheme == "https" || os.Getenv(tokenHTTPEnv) == "1"
+}
+
+// checkRedirect follows up to 10 redirects like the default policy, and
+// drops the JJ_TOKEN token from a redirect to a URL it's not sent to.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	tok := os.Getenv(tokenEnv)
+	if tok != "" && req.Header.Get("Authorization") == "Bearer "+tok &&
+		!sendToken(req.URL) {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// isURL reports whether the name of an input or output file is an http(s)
+// URL.
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") ||
+		strings.HasPrefix(name, "https://")
+}
+
+// newRequest returns a request for the URL with the --header headers, and
+// the bearer token from JJ_TOKEN when there is one and the URL is https. The
+// request is canceled when ctx is done.
+func newRequest(ctx context.Context, a args, method, rawurl string,
+	body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawurl, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range a.headers {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if tok := os.Getenv(tokenEnv); tok != "" &&
+		req.Header.Get("Authorization") == "" && sendToken(req.URL) {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	return req, nil
+}
+
+// doRequest sends the request, and fails like the client does when the
+// response has a status other than 2xx.
+func doRequest(req *http.Request) (*http.Response, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		op := req.Method[:1] + strings.ToLower(req.Method[1:])
+		return nil, &url.Error{Op: op, URL: req.URL.String(),
+			Err: errors.New(resp.Status)}
+	}
+	return resp, nil
+}
+
+// openInput opens the named input file, or the body of a GET of it when
+// it's a URL.
+func openInput(ctx context.Context, a args, name string) (io.ReadCloser, error) {
+	if !isURL(name) {
+		return os.Open(name)
+	}
+	req, err := newRequest(ctx, a, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// readInputFile reads the named input file, which may be a URL.
+func readInputFile(ctx context.Context, a args, name string) ([]byte, error) {
+	if !isURL(name) {
+		return os.ReadFile(name)
+	}
+	rc, err := openInput(ctx, a, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// createUpload returns the output for -o or -I with a URL, which is sent to
+// it with --method, PUT by default, once it's been fully written. The upload
+// is canceled when ctx is done.
+func createUpload(ctx context.Context, a args, rawurl string) *outputFile {
+	buf := &bytes.Buffer{}
+	method := http.MethodPut
+	if a.method != nil {
+		method = strings.ToUpper(*a.method)
+	}
+	ctype := "application/json"
+	switch a.to {
+	case "yaml":
+		ctype = "application/yaml"
+	case "toml":
+		ctype = "application/toml"
+	}
+	upload := func() error {
+		req, err := newRequest(ctx, a, method, rawurl,
+			bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return err
+		}
+		if req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", ctype)
+		}
+		resp, err := doRequest(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+	return &outputFile{Writer: buf, upload: upload}
+}
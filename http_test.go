@@ -0,0 +1,37 @@
+package jj
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTokenOnlyOverHTTPS(t *testing.T) {
+	t.Setenv(tokenEnv, "secret")
+	tests := []struct {
+		url, insecure, want string
+	}{
+		{"https://example.com/a.json", "", "Bearer secret"},
+		{"http://example.com/a.json", "", ""},
+		{"http://example.com/a.json", "1", "Bearer secret"},
+	}
+	for _, tt := range tests {
+		t.Setenv(tokenHTTPEnv, tt.insecure)
+		req, err := newRequest(context.Background(), args{}, "GET", tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header.Get("Authorization"); got != tt.want {
+			t.Errorf("%s with %s=%q: Authorization %q, want %q", tt.url,
+				tokenHTTPEnv, tt.insecure, got, tt.want)
+		}
+		// a redirect to the URL drops the token the same way
+		req.Header.Set("Authorization", "Bearer secret")
+		if err := checkRedirect(req, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header.Get("Authorization"); got != tt.want {
+			t.Errorf("redirect to %s with %s=%q: Authorization %q, want %q",
+				tt.url, tokenHTTPEnv, tt.insecure, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package jj
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+)
+
+// contextReader reads from r until ctx is done, which stops a read of a
+// large or slow input between two of its chunks.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// readInput reads the input document from in, or from the input files
+// concatenated together as a stream of JSON values.
+func readInput(ctx context.Context, a args, in io.Reader) ([]byte, error) {
+	if len(a.infiles) == 0 {
+		return io.ReadAll(contextReader{ctx, in})
+	}
+	if len(a.infiles) == 1 {
+		return readInputFile(ctx, a, a.infiles[0])
+	}
+	var input []byte
+	for _, name := range a.infiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := readInputFile(ctx, a, name)
+		if err != nil {
+			return nil, err
+		}
+		input = append(input, data...)
+		if len(input) > 0 && input[len(input)-1] != '\n' {
+			input = append(input, '\n')
+		}
+	}
+	return input, nil
+}
+
+// slurp collects the consecutive top-level JSON values in data into a
+// single JSON array.
+func slurp(data []byte) ([]byte, error) {
+	docs, err := splitDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+	out := []byte{'['}
+	for i, doc := range docs {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, doc...)
+	}
+	return append(out, ']'), nil
+}
+
+// splitDocuments returns the consecutive top-level JSON values in data.
+func splitDocuments(data []byte) ([][]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var docs [][]byte
+	for {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				return docs, nil
+			}
+			return nil, decodeError(err, dec.InputOffset())
+		}
+		docs = append(docs, v)
+	}
+}
+
+// mergeInputs deep-merges the input documents from left to right, the
+// documents of each input file in turn, or those read from in when there are
+// no files. An object is merged into an object, any other document replaces
+// the one before. The format of each file is taken from its extension
+// unless --from is given.
+func mergeInputs(ctx context.Context, a args, in io.Reader) ([]byte, error) {
+	names := a.infiles
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	var merged []byte
+	for _, name := range names {
+		docs, err := inputDocuments(ctx, a, in, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			dst, src := gjson.ParseBytes(merged), gjson.ParseBytes(doc)
+			if merged != nil && dst.IsObject() && src.IsObject() {
+				merged = mergeObjects(dst, src)
+			} else {
+				merged = doc
+			}
+		}
+	}
+	if merged == nil {
+		return nil, errors.New("no input documents to merge")
+	}
+	return merged, nil
+}
+
+// inputDocuments reads the documents of the input file name, or of in when
+// name is "-", as JSON. The format of the file is taken from its extension
+// unless --from is given, and an error names the file and the format it was
+// read as.
+func inputDocuments(ctx context.Context, a args, in io.Reader,
+	name string) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(contextReader{ctx, in})
+	} else {
+		data, err = readInputFile(ctx, a, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.envsubst {
+		data = envSubst(data)
+	}
+	from := a.from
+	if from == "" && name != "-" {
+		from = formatOf(name)
+	}
+	var docs [][]byte
+	switch from {
+	case "yaml":
+		data, err = yamlToJSON(data)
+		docs = [][]byte{data}
+	case "toml":
+		data, _, err = tomlToJSON(data)
+		docs = [][]byte{data}
+	case "jsonc", "json5":
+		data, err = relaxedToJSON(data)
+		docs = [][]byte{data}
+	default:
+		from = "json"
+		docs, err = splitDocuments(data)
+	}
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("%s (read as %s): %v", name, from,
+			err))
+	}
+	if a.strict && from == "json" {
+		for _, doc := range docs {
+			if err := strictJSON(doc); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return docs, nil
+}
+
+// explode returns the elements of the JSON array in data as JSON Lines, one
+// compact element per line.
+func explode(data []byte) ([]byte, error) {
+	arr := gjson.ParseBytes(data)
+	if !arr.IsArray() {
+		return nil, errors.New("--explode requires an array")
+	}
+	var out []byte
+	arr.ForEach(func(_, v gjson.Result) bool {
+		out = append(out, pretty.Ugly([]byte(v.Raw))...)
+		out = append(out, '\n')
+		return true
+	})
+	return out, nil
+}
+
+// decodeError describes an encoding/json decoding failure along with the
+// byte offset where it happened.
+func decodeError(err error, offset int64) error {
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) {
+		offset = serr.Offset
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = errors.New("unexpected end of JSON input")
+	}
+	return invalidInput(fmt.Errorf("invalid JSON at byte offset %d: %v",
+		offset, err))
+}
+
+// readValues replaces the file names of the values given with -V with the
+// contents of the files. A raw value or one forced with -N or --type is
+// trimmed of surrounding whitespace, while a string value is kept exactly as
+// it is.
+func readValues(edits []edit, in io.Reader) error {
+	for i := range edits {
+		if !edits[i].file {
+			continue
+		}
+		data, err := readFile(edits[i].value, in)
+		if err != nil {
+			return err
+		}
+		if edits[i].raw || (edits[i].force != 0 && edits[i].force != 's') {
+			data = bytes.TrimSpace(data)
+		}
+		edits[i].value = string(data)
+	}
+	return nil
+}
+
+// readFile reads the named file, or in when the name is "-".
+func readFile(name string, in io.Reader) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(in)
+	}
+	return os.ReadFile(name)
+}
+
+// readDocument reads the named file like readFile, converting it to JSON
+// when it's YAML, TOML, JSONC, or JSON5, and checks that it's valid.
+func readDocument(name string, in io.Reader) ([]byte, error) {
+	data, err := readFile(name, in)
+	if err != nil {
+		return nil, err
+	}
+	switch formatOf(name) {
+	case "yaml":
+		data, err = yamlToJSON(data)
+	case "toml":
+		data, _, err = tomlToJSON(data)
+	case "jsonc", "json5":
+		data, err = relaxedToJSON(data)
+	default:
+		if err == nil && !gjson.ValidBytes(data) {
+			err = errors.New("invalid JSON")
+		}
+	}
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("%s: %w", name, err))
+	}
+	return data, nil
+}
@@ -2,12 +2,19 @@ package jj
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
-	isatty "github.com/mattn/go-isatty"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/pretty"
 	"github.com/tidwall/sjson"
@@ -17,74 +24,541 @@ var (
 	version = "0.0.1"
 	tag     = "jj - JSON Stream Editor " + version
 	usage   = `
-usage: jj [-v value] [-purnOD] [-i infile] [-o outfile] keypath
+usage: jj [-v value|-V file] [-a value] [-purnsNSODIJMetmqkcwxj0] [-i infile] [-o outfile] keypath
+          [-v value keypath ...] [keypath ...]
 
 examples: jj keypath                      read value from stdin
       or: jj -i infile keypath            read value from infile
       or: jj -v value keypath             edit value
       or: jj -v value -o outfile keypath  edit value and write to outfile
+      or: jj -v v1 keypath1 -v v2 keypath2
+                                          edit several values at once
+      or: jj -D keypath1 -v v2 keypath2   delete and edit several values, in
+                                          the order given
+      or: jj keypath1 keypath2            read several values as an array
+      or: jj patch -f patchfile           apply a JSON Patch (RFC 6902)
+      or: jj diff file1 file2             output the JSON Patch from file1 to
+                                          file2, either file may be "-"
+      or: jj cmp file1 file2 [keypath]    output the values that differ,
+                                          exiting with 1 when there are any
+      or: jj validate -s schema           validate against a JSON Schema,
+                                          writing each violation
+      or: jj check                        check that the input is strict
+                                          JSON, like --validate --strict
+      or: jj escape key ...               output the keypath of the plain
+                                          keys, escaping . * ? and the like
 
 options:
-      -v value             Edit JSON key path value
+      -v value             Edit JSON key path value, may be repeated with each
+                           -v followed by its own keypath, an index of -1
+                           appends to an array, @file reads the value like
+                           -V does and @@ starts a value with a literal @
+      -V file              Edit JSON key path value, reading the value from
+                           file, or from stdin when file is "-" and the
+                           document is read with -i, the value is a string
+                           unless -r is given
+      -m                   Deep-merge the object value of -v or -V into the
+                           object at keypath instead of replacing it
+      --merge-patch file   Apply the JSON Merge Patch (RFC 7386) in file to
+                           the document, or read it from stdin when file is
+                           "-" and the document is read with -i
+      -a value             Append value to the array at keypath, creating the
+                           array when it does not exist, may be repeated
+      --append keypath     Append the value of the next -v or -V to the array
+                           at keypath, like -a
+      --insert keypath     Insert the value of the next -v or -V into the
+                           array at keypath, which ends with the index to
+                           insert at, moving the later elements up
+      --move from to       Move the value at keypath from to keypath to,
+                           inserting it when to is an index of an array
       -p                   Make json pretty, keypath is optional
       -u                   Make json ugly, keypath is optional
+      -S, --sort-keys      Sort object keys, implies -p unless -u is given
+      --indent indent      Indent pretty json with the whitespace string,
+                           where \t is a tab and \s a space, with a number
+                           of spaces, or with tab, implies -p
+      --width n            Keep short arrays on one line when they fit in n
+                           columns (default 80), implies -p
+      --prefix prefix      Start every pretty json line with prefix, implies
+                           -p
       -r                   Use raw values, otherwise types are auto-detected
+      -s                   Set the value of the next -v, -V, or -a as a string
+      -N                   Set the value of the next -v, -V, or -a as a
+                           number, true, false, or null, failing otherwise
+      --type type          Convert the value of the next -v, -V, or -a to
+                           string, number, bool, null, or json, failing when
+                           it can't be, like "--type number -v +1 count"
       -n                   Do not output color or extra formatting
+      --color when         Color the output: auto when writing to a terminal
+                           (default), always, or never
+      --color-theme theme  Color style, with the same values as JJ_STYLE
       -O                   Performance boost for value updates
-      -D                   Delete the value at the specified key path
+      -D                   Delete the value at the specified key path, may be
+                           repeated with each -D followed by its own keypath,
+                           exits with 1 when there is no value to delete
+      -q                   Do not output the document when there is no value
+                           to delete with -D, or the value with --exists
       -l                   Output array values on multiple lines
-      -i infile            Use input file instead of stdin
-      -o outfile           Use output file instead of stdout
-      keypath              JSON key path (like "name.last")
+      -c, --csv            Output an array of objects as CSV, with a header
+                           row of all the object keys
+      --tsv                Output an array of objects as tab separated values
+      --export             Output an object as shell lines, like
+                           export KEY='value', for use with eval
+      --template text      Output the result rendered with a Go template,
+                           like '{{.name.first}} <{{.email}}>', with the
+                           functions join, default, number, and json
+      -0                   Separate the array values of -l with a NUL byte
+                           instead of a newline and write string values
+                           without quotes, for use with xargs -0
+      --raw-output0        End the output with a NUL byte instead of a
+                           newline, or each value with -l, and each document
+                           with -J or -M
+      -j, --join-output    Do not end the output with a newline, string
+                           values are written exactly as they are
+      -k                   Read the values at the keypaths into an object with
+                           the keypaths as keys, leaving out missing values,
+                           instead of into an array with null for them
+      --output mode        Read the values at the keypaths as an array
+                           (default), as an object like -k, or as lines,
+                           one value per line like -l; an explicit array
+                           holds even a single keypath's value
+      -e, --exit-status    Exit with 1 and output nothing when the keypath
+                           being read does not exist, a null or empty value
+                           still exits with 0
+      --exists             Exit with 0 when the keypath exists, or when its
+                           value is equal to the value of -v, otherwise
+                           with 1, the value is output unless -q is given
+      -t                   Output the type of the value at keypath: string,
+                           number, true, false, null, array, object, or
+                           null-missing when it does not exist
+      -I                   Edit the input file in place, requires -i
+      --diff               Output a diff of the changes that -v, -V, -a, -m,
+                           or -D would make instead of the edited document,
+                           no file is written
+      --backup             Keep a copy of the original file as infile.bak
+                           when editing in place
+      -i infile            Use input file instead of stdin, may be repeated
+                           to process the documents of several files one
+                           after another, like --concat, an http(s) URL is
+                           read with a GET
+      --header line        Send the header "Name: value" to http(s) URLs,
+                           may be repeated
+      --each pattern       Perform the operation on each file that matches
+                           the glob pattern, like 'conf/*.json', several at
+                           once, editing them in place with -I, the exit
+                           status is the highest of the files
+      --jobs n             Process up to n --each files at once, the number
+                           of CPUs by default
+      --mmap               Map the -i file into memory instead of reading it,
+                           so that reading a value from a very large file
+                           only uses the memory of the parts it looks at,
+                           edits still read the whole file
+      -x                   Explore the document interactively, reading
+                           keypaths and edits from the terminal with tab
+                           completion of the keys, :h shows the help
+      -w, --watch          Run again each time the -i file changes, writing
+                           the new output, until interrupted
+      --slurp              Read all input documents into a single array
+      --concat             Process each input document on its own, like -M,
+                           writing the results one after another, each file
+                           may be json, yaml, or toml
+      --merge              Deep-merge the input documents from left to right
+                           into one, like base.json, env.json, and local.json
+                           given with -i, each file may be json, yaml, or toml
+      --explode            Output each element of the resulting array on its
+                           own line, the reverse of --slurp
+      -J                   Process the input as newline delimited JSON, one
+                           document per line, writing one line for each
+      -M, --stream         Process each of the JSON documents that follow one
+                           another in the input, writing one line for each
+                           as soon as it's read, in constant memory
+      --validate           Only check that the input is valid JSON, or that
+                           each line is with -J, exiting with 4 if it is not
+      --strict             Fail when the input is not valid JSON or has
+                           duplicate object keys, telling the line and column,
+                           and with -J fail on such lines instead of writing
+                           them unchanged
+      -o outfile           Use output file instead of stdout, a regular file
+                           is only replaced once the output is complete, an
+                           http(s) URL is sent the output with a PUT
+      --method method      Send the output to a URL with PUT (default),
+                           POST, or PATCH
+      --env-subst          Replace ${VAR} and ${VAR:-default} in the input
+                           with the values of the environment variables
+      --from format        Read the input as json, yaml, toml, jsonc, or
+                           json5, the default is taken from the -i file
+                           extension: .yaml, .yml, .toml, .jsonc, or .json5
+      --to format          Write the output as json, yaml, or toml, the
+                           default is taken from the -o file extension
+      --jsonc              Read the input as JSON with comments and trailing
+                           commas, like --from jsonc, the comments are kept
+                           when the document is edited unless --to json,
+                           -p, or -u is given
+      --json5              Read the input as JSON5, like --from json5
+      --on-error mode      What to do on a recoverable error: abort (default),
+                           skip the output, or emit an empty result
+      --sort-by field      Sort the array of objects at keypath by field,
+                           keypath is optional
+      --sort path          Sort the array at the keypath path in the
+                           document, by the value of --sort-by when given or
+                           else by the elements themselves, and output the
+                           whole document
+      --desc               Sort in descending order
+      --annotate-with file Output JSONC with the comments from file, a JSON
+                           object that maps keypaths to comment strings
+      --find-value value   Output the keypath where value is found, keypath
+                           is optional and limits the search to a subtree,
+                           exits with 1 when the value is not found
+      --all                Output all matching keypaths as an array
+      --find key           Output the keypath and value of every member
+                           named key, which may have * and ? wildcards, as an
+                           array of {"path":...,"value":...}, keypath is
+                           optional and limits the search to a subtree
+      --replace-null value Replace every null in the document with value
+      --normalize-ws       Collapse and trim the whitespace in every string
+                           value of the document
+      --path-prefix path   Limit --replace-null and --normalize-ws to the
+                           subtree at path
+      --paths              Output the keypaths of all leaf values, keypath is
+                           optional and limits the output to a subtree
+      --flatten            Output an object with the keypath and value of
+                           every leaf value, like {"a.b.0.c":1}, keypath is
+                           optional and the keypaths are relative to it
+      --unflatten          Output the document made from such an object,
+                           setting each value at its keypath
+      --order dfs|bfs      Walk order for --paths, --find, and --flatten,
+                           depth-first (default) or breadth-first
+      --omit-null          Leave null object members out of the output, the
+                           document itself is not modified
+      --omit-null-arrays   Also leave null array elements out of the output
+      --infer-schema       Output a JSON Schema inferred from the document,
+                           keypath is optional
+      --unquote-keys       Write object keys that are identifiers without
+                           quotes, the output is not strict JSON
+      --preserve-number-format
+                           Output numbers exactly as they are written in the
+                           document, so that 1.00 is not shortened to 1
+      --explain-query      Describe how the keypath is parsed, without reading
+                           any input
+      --merge-keys paths   Deep-merge the objects at the comma separated
+                           keypaths, in order, and output the result
+      --size               Output the byte sizes of the value at keypath and
+                           of the whole document instead of the value
+      --stats              Output a summary of the value at keypath, or of
+                           the document: its type, size in bytes, number of
+                           members or elements, number of keys, depth, and
+                           the count of values of each type
+      --deep-unstring      Expand string values that contain encoded JSON
+                           objects or arrays, keypath is optional
+      --max-unstring-depth n
+                           Maximum levels of encoding to expand (default 8)
+      --eq-paths p1 p2     Exit with 0 when the values at the two keypaths are
+                           deeply equal, otherwise with 1
+      --literal-path       Take the keypaths as plain keys separated by
+                           dots, so that * ? and the like are part of the key
+      --sep sep            Separate the keys of --literal-path with sep
+                           instead, like "/", so that keys may have dots
+      --modifier name=command
+                           Add the modifier @name to keypaths, which runs
+                           command with the value on stdin, a string
+                           without quotes, and the modifier argument in
+                           JJ_MODIFIER_ARG, the output is the new value,
+                           and a failing command fails with exit status 5
+      --wrap key           Output the result as the value of key in a new
+                           object, like {"key":result}
+      --error-format fmt   Write errors as text (default), or as json with
+                           one object for each, like
+                           {"error":"...","kind":"io","code":3}
+      --timeout duration   Abort with exit code 124 when the operation runs
+                           longer than the duration (like "30s" or "2m")
+      -f patchfile         JSON Patch to apply with the patch command, or "-"
+                           to read it from stdin when the document is read
+                           with -i
+      -s, --schema file    JSON Schema to validate against with the validate
+                           command, exits with 1 when the input is not valid
+      keypath              JSON key path (like "name.last"), patch, diff,
+                           cmp, validate, check, and escape are commands when
+                           they are the first argument, a # component, or *
+                           and ? in a key, edit every matching value (like
+                           "items.#.count"), and modifiers transform the
+                           value read: @reverse, @flatten, @join, @keys,
+                           @values, @group, @this, @valid, @pretty, @ugly,
+                           @tostr, and @fromstr (like "children|@reverse")
+
+environment:
+      JJ_STYLE             Color style for terminal output: default, light,
+                           monokai, solarized, none, or SGR codes for each
+                           kind of value, like "key=34;string=32;number=1;33",
+                           the kinds are key, string, number, true, false,
+                           bool, null, escape
+      JJ_COLORS            The same as JJ_STYLE, when JJ_STYLE is not set
+      JJ_TOKEN             Bearer token sent to https URLs, unless an
+                           Authorization header is given with --header
+      JJ_TOKEN_HTTP        Set to 1 to send JJ_TOKEN to plain http URLs too
+
+exit status:
+      0                    Success
+      1                    The keypath is missing with -e or --exists, there
+                           is nothing to delete with -D, --find-value finds
+                           nothing, or a check fails
+      2                    The command line is not valid
+      3                    A file or URL could not be read or written
+      4                    The input is not valid JSON, YAML, or TOML
+      5                    Any other error
+      124                  The --timeout expired
 
 for more info: https://github.com/nuvolaris/jj
 `
 )
 
+// prettyOptions returns the options for pretty printing, creating them
+// from the defaults when they are first changed.
+func (a *args) prettyOptions() *pretty.Options {
+	if a.popts == nil {
+		opts := *pretty.DefaultOptions
+		a.popts = &opts
+	}
+	return a.popts
+}
+
+// parseIndent parses the --indent option, either a number of spaces, tab,
+// or a string of whitespace, where \t is a tab and \s is a space.
+func parseIndent(s string) (string, bool) {
+	if s == "tab" {
+		return "\t", true
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n > 16 {
+			return "", false
+		}
+		return strings.Repeat(" ", n), true
+	}
+	s = strings.NewReplacer(`\t`, "\t", `\s`, " ").Replace(s)
+	if s == "" {
+		return "", false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' && s[i] != '\t' {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// edit is a value to set at a keypath, as requested with -v.
+type edit struct {
+	keypath string
+	value   string
+	raw     bool
+	append  bool // append the value to the array at keypath, as with -a
+	file    bool // value is the name of the file to read with -V
+	at      bool // the file was given to -v as @file
+	insert  bool // insert the value into the array, as with --insert
+	move    bool // move the value at from to keypath, as with --move
+	from    string
+	del     bool // delete the value at keypath, as with -D
+	force   byte // 's' or 'N' with -s or -N, or the --type from typeForces
+}
+
+// option returns the option that the edit was given with.
+func (e edit) option() string {
+	if e.move {
+		return "--move"
+	} else if e.insert {
+		return "--insert"
+	} else if e.file && !e.at {
+		return "-V"
+	} else if e.del {
+		return "-D"
+	} else if e.append {
+		return "-a"
+	}
+	return "-v"
+}
+
 type args struct {
-	infile    *string
-	outfile   *string
-	value     *string
-	raw       bool
-	del       bool
-	opt       bool
-	keypathok bool
-	keypath   string
-	pretty    bool
-	ugly      bool
-	notty     bool
-	lines     bool
-}
-
-func fail(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%s\n", tag)
+	infiles    []string
+	outfile    *string
+	edits      []edit
+	pending    int // index of the first edit still waiting for its keypath
+	raw        bool
+	del        bool
+	opt        bool
+	keypathok  bool
+	keypath    string
+	pretty     bool
+	ugly       bool
+	notty      bool
+	lines      bool
+	onerror    string
+	timeout    time.Duration
+	sortby     *string
+	sortpath   *string // the array sorted in place with --sort
+	desc       bool
+	annotate   *string
+	findvalue  *string
+	findkey    *string
+	all        bool
+	replnull   *string
+	prefix     *string
+	paths      bool
+	flatten    bool
+	unflatten  bool
+	order      string
+	slurp      bool
+	modifiers  []string // the --modifier name=command definitions
+	export     bool     // --export, write the object as shell lines
+	envsubst   bool     // --env-subst, expand ${VAR} in the input
+	repl       bool     // -x, explore the document from the terminal
+	mmap       bool     // --mmap, map the input file into memory
+	literal    bool     // --literal-path, keypaths are plain keys
+	sep        *string  // the separator of the keys with --literal-path
+	concat     bool     // --concat, each input file is its own document
+	mergedocs  bool     // --merge, deep-merge the input documents
+	headers    []string // the --header lines sent to http(s) URLs
+	each       *string  // the --each pattern of the files to process
+	jobs       int      // how many --each files are processed at once
+	method     *string  // how -o sends to a URL, PUT by default
+	omitnull   bool
+	omitnulla  bool
+	infer      bool
+	unquote    bool
+	keepnum    bool
+	explainq   bool
+	mergekeys  *string
+	normws     bool
+	size       bool
+	stats      bool
+	unstring   bool
+	unstrmax   int
+	eqpaths    []string
+	wrap       *string
+	inplace    bool
+	backup     bool
+	ndjson     bool
+	strict     bool
+	exists     bool
+	popts      *pretty.Options // pretty options given with -S and --indent
+	typeof     bool
+	merge      bool
+	multi      bool
+	style      *pretty.Style // color style, from JJ_STYLE
+	theme      *string       // color style given with --color-theme
+	color      string        // when to color the output: auto, always, never
+	quiet      bool
+	keypaths   []string // the keypaths after the first one, to read them all
+	keyed      bool
+	asarray    bool  // --output array, even a single keypath is read as an array
+	test       bool  // --exists, only the exit code tells the result
+	expect     *edit // the -v value that --exists compares with
+	target     *edit // the --append or --insert waiting for its value
+	watch      bool
+	nul        bool
+	nulend     bool // --raw-output0, end the output with a NUL byte
+	join       bool // -j, no newline at the end of the output
+	force      byte // -s, -N, or --type waiting for the next edit
+	validate   bool
+	diff       bool
+	command    string // patch or diff
+	patchfile  *string
+	patch      []byte // the JSON Patch read from patchfile
+	mpatchfile *string
+	mpatch     []byte // the JSON Merge Patch read from mpatchfile
+	schemafile *string
+	schema     []byte // the JSON Schema read from schemafile
+	from       string // input format, json, yaml, or toml
+	to         string // output format, json, yaml, or toml
+	csv        rune   // field separator of the -c or --tsv output
+	explode    bool
+	// tmpl is the --template that the result is rendered with
+	tmpl *template.Template
+	// tomldates are the dates and times of the TOML input, which are
+	// written back as dates and times rather than strings
+	tomldates tomlDates
+}
+
+// addEdit adds an edit with the type forced with -s, -N, or --type, if any,
+// and the keypath given before it with --append or --insert.
+func (a *args) addEdit(e edit) {
+	e.force = a.force
+	a.force = 0
+	if a.target != nil {
+		e.keypath = a.target.keypath
+		e.append = e.append || a.target.append
+		e.insert = a.target.insert
+		a.target = nil
+		a.pending++
+	}
+	a.edits = append(a.edits, e)
+}
+
+func fail(w io.Writer, format string, args ...interface{}) {
+	if r, ok := w.(usageReporter); ok {
+		r.usageError(fmt.Sprintf(format, args...))
+		return
+	}
+	fmt.Fprintf(w, "%s\n", tag)
 	if format != "" {
-		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		fmt.Fprintf(w, format+"\n", args...)
 	}
-	fmt.Fprintf(os.Stderr, "%s\n", usage)
+	fmt.Fprintf(w, "%s\n", usage)
 }
 
-func help() {
+func help(w io.Writer) {
 	buf := &bytes.Buffer{}
 	fmt.Fprintf(buf, "%s\n", tag)
 	fmt.Fprintf(buf, "%s\n", usage)
-	os.Stdout.Write(buf.Bytes())
+	w.Write(buf.Bytes())
+}
+
+// newArgs returns the arguments with their default values.
+func newArgs() args {
+	return args{onerror: "abort", order: "dfs", unstrmax: 8}
 }
 
-func parseArgs() (args, bool, int) {
-	var a args
-	for i := 1; i < len(os.Args); i++ {
-		switch os.Args[i] {
+func parseArgs(argv []string, out, errOut io.Writer) (args, bool, int) {
+	a := newArgs()
+	if len(argv) > 0 && argv[0] == "escape" {
+		// the keys are taken as they are, even when they start with a -
+		if len(argv) < 2 {
+			fail(errOut, "missing key to escape")
+			return a, true, exitUsage
+		}
+		a.command = "escape"
+		a.keypaths = argv[1:]
+		return a, false, 0
+	}
+	if len(argv) > 0 && (argv[0] == "patch" || argv[0] == "diff" ||
+		argv[0] == "cmp" || argv[0] == "validate" || argv[0] == "check") {
+		a.command = argv[0]
+		argv = argv[1:]
+	}
+	if a.command == "check" {
+		// check is --validate --strict
+		a.command = ""
+		a.validate, a.strict = true, true
+	}
+	for i := 0; i < len(argv); i++ {
+		switch argv[i] {
 		default:
-			if len(os.Args[i]) > 1 && os.Args[i][0] == '-' {
-				for j := 1; j < len(os.Args[i]); j++ {
-					switch os.Args[i][j] {
+			if argv[i] == "-s" && a.command == "validate" {
+				// -s names the schema with the validate command
+				if i++; i >= len(argv) {
+					fail(errOut, "argument missing after: \"-s\"")
+					return a, true, exitUsage
+				}
+				a.schemafile = &argv[i]
+				continue
+			}
+			if len(argv[i]) > 1 && argv[i][0] == '-' {
+				for j := 1; j < len(argv[i]); j++ {
+					switch argv[i][j] {
 					default:
-						fail("unknown option argument: \"-%c\"", os.Args[i][j])
-						return a, true, 1
+						fail(errOut, "unknown option argument: \"-%c\"", argv[i][j])
+						return a, true, exitUsage
 					case '-':
-						fail("unknown option argument: \"%s\"", os.Args[i])
-						return a, true, 1
+						fail(errOut, "unknown option argument: \"%s\"", argv[i])
+						return a, true, exitUsage
 					case 'p':
 						a.pretty = true
 					case 'u':
@@ -95,173 +569,1907 @@ func parseArgs() (args, bool, int) {
 						a.opt = true
 					case 'D':
 						a.del = true
+						a.edits = append(a.edits, edit{del: true})
 					case 'n':
 						a.notty = true
 					case 'l':
 						a.lines = true
+					case 'I':
+						a.inplace = true
+					case 'J':
+						a.ndjson = true
+					case 'M':
+						a.multi = true
+					case 'e':
+						a.exists = true
+					case 't':
+						a.typeof = true
+					case 'm':
+						a.merge = true
+					case 'q':
+						a.quiet = true
+					case 'k':
+						a.keyed = true
+					case 'c':
+						a.csv = ','
+					case 'w':
+						a.watch = true
+					case 'x':
+						a.repl = true
+					case '0':
+						a.nul = true
+					case 'j':
+						a.join = true
+					case 's', 'N':
+						if a.force != 0 && a.force != argv[i][j] {
+							fail(errOut, "-s, -N, and --type cannot be used "+
+								"together")
+							return a, true, exitUsage
+						}
+						a.force = argv[i][j]
+					case 'S':
+						a.prettyOptions().SortKeys = true
 					}
 				}
 				continue
 			}
-			if !a.keypathok {
+			if a.pending < len(a.edits) {
+				a.edits[a.pending].keypath = argv[i]
+				a.pending++
+			} else if !a.keypathok {
 				a.keypathok = true
-				a.keypath = os.Args[i]
+				a.keypath = argv[i]
 			} else {
-				fail("unknown option argument: \"%s\"", a.keypath)
-				return a, true, 1
+				a.keypaths = append(a.keypaths, argv[i])
 			}
-		case "-v", "-i", "-o":
-			arg := os.Args[i]
+		case "-v", "-V", "-a", "-i", "-o", "--on-error", "--timeout", "--sort-by",
+			"--annotate-with", "--find-value", "--replace-null",
+			"--path-prefix", "--order", "--merge-keys",
+			"--max-unstring-depth", "--wrap", "--indent", "-f",
+			"--merge-patch", "--from", "--to", "--output", "--schema",
+			"--find", "--sort", "--width", "--prefix", "--color",
+			"--color-theme", "--append", "--insert", "--modifier", "--sep",
+			"--error-format", "--header", "--method", "--type",
+			"--template", "--each", "--jobs":
+			arg := argv[i]
 			i++
-			if i >= len(os.Args) {
-				fail("argument missing after: \"%s\"", arg)
-				return a, true, 1
+			if i >= len(argv) {
+				fail(errOut, "argument missing after: \"%s\"", arg)
+				return a, true, exitUsage
 			}
 			switch arg {
 			case "-v":
-				a.value = &os.Args[i]
+				switch {
+				case strings.HasPrefix(argv[i], "@@"):
+					// a literal value that starts with @
+					a.addEdit(edit{value: argv[i][1:]})
+				case strings.HasPrefix(argv[i], "@"):
+					a.addEdit(edit{value: argv[i][1:], file: true, at: true})
+				default:
+					a.addEdit(edit{value: argv[i]})
+				}
+			case "-V":
+				a.addEdit(edit{value: argv[i], file: true})
+			case "-a":
+				a.addEdit(edit{value: argv[i], append: true})
 			case "-i":
-				a.infile = &os.Args[i]
+				a.infiles = append(a.infiles, argv[i])
 			case "-o":
-				a.outfile = &os.Args[i]
+				a.outfile = &argv[i]
+			case "--on-error":
+				switch argv[i] {
+				case "abort", "skip", "empty":
+					a.onerror = argv[i]
+				default:
+					fail(errOut, "invalid --on-error mode: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+			case "--timeout":
+				d, err := time.ParseDuration(argv[i])
+				if err != nil || d <= 0 {
+					fail(errOut, "invalid --timeout duration: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+				a.timeout = d
+			case "--sort-by":
+				a.sortby = &argv[i]
+			case "--sort":
+				a.sortpath = &argv[i]
+			case "--annotate-with":
+				a.annotate = &argv[i]
+			case "--find-value":
+				a.findvalue = &argv[i]
+			case "--find":
+				a.findkey = &argv[i]
+			case "--replace-null":
+				a.replnull = &argv[i]
+			case "--path-prefix":
+				a.prefix = &argv[i]
+			case "--merge-keys":
+				a.mergekeys = &argv[i]
+			case "--wrap":
+				a.wrap = &argv[i]
+			case "-f":
+				a.patchfile = &argv[i]
+			case "--merge-patch":
+				a.mpatchfile = &argv[i]
+			case "--schema":
+				a.schemafile = &argv[i]
+			case "--output":
+				// the same as -l or -k, the array is the default
+				switch argv[i] {
+				case "lines":
+					a.lines = true
+				case "object":
+					a.keyed = true
+				case "array":
+					a.asarray = true
+				default:
+					fail(errOut, "invalid --output: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+			case "--from", "--to":
+				switch argv[i] {
+				case "json", "yaml", "toml", "jsonc", "json5":
+				default:
+					fail(errOut, "invalid %s: \"%s\"", arg, argv[i])
+					return a, true, exitUsage
+				}
+				if arg == "--from" {
+					a.from = argv[i]
+				} else {
+					a.to = argv[i]
+				}
+			case "--indent":
+				indent, ok := parseIndent(argv[i])
+				if !ok {
+					fail(errOut, "invalid --indent: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+				a.prettyOptions().Indent = indent
+			case "--width":
+				n, err := strconv.Atoi(argv[i])
+				if err != nil || n < 0 {
+					fail(errOut, "invalid --width: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+				a.prettyOptions().Width = n
+			case "--prefix":
+				a.prettyOptions().Prefix = argv[i]
+			case "--color":
+				switch argv[i] {
+				case "auto", "always":
+				case "never":
+					a.notty = true
+				default:
+					fail(errOut, "invalid --color: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+				a.color = argv[i]
+			case "--color-theme":
+				a.theme = &argv[i]
+			case "--sep":
+				a.sep = &argv[i]
+			case "--each":
+				if _, err := filepath.Match(argv[i], ""); err != nil {
+					fail(errOut, "invalid --each pattern: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+				a.each = &argv[i]
+			case "--jobs":
+				n, err := strconv.Atoi(argv[i])
+				if err != nil || n < 1 {
+					fail(errOut, "invalid --jobs: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+				a.jobs = n
+			case "--template":
+				t, err := parseTemplate(argv[i])
+				if err != nil {
+					fail(errOut, "invalid --template: %v", err)
+					return a, true, exitUsage
+				}
+				a.tmpl = t
+			case "--type":
+				force, ok := typeForces[argv[i]]
+				if !ok {
+					fail(errOut, "invalid --type: \"%s\", use string, number, "+
+						"bool, null, or json", argv[i])
+					return a, true, exitUsage
+				}
+				if a.force != 0 && a.force != force {
+					fail(errOut, "-s, -N, and --type cannot be used together")
+					return a, true, exitUsage
+				}
+				a.force = force
+			case "--error-format":
+				// it's already taken by Run, only the value is checked here
+				if argv[i] != "text" && argv[i] != "json" {
+					fail(errOut, "invalid --error-format: \"%s\", use text or "+
+						"json", argv[i])
+					return a, true, exitUsage
+				}
+			case "--header":
+				if name, _, ok := strings.Cut(argv[i], ":"); !ok ||
+					strings.TrimSpace(name) == "" {
+					fail(errOut, "invalid --header: \"%s\", use \"Name: value\"",
+						argv[i])
+					return a, true, exitUsage
+				}
+				a.headers = append(a.headers, argv[i])
+			case "--method":
+				switch strings.ToUpper(argv[i]) {
+				case "PUT", "POST", "PATCH":
+				default:
+					fail(errOut, "invalid --method: \"%s\", use PUT, POST, or "+
+						"PATCH", argv[i])
+					return a, true, exitUsage
+				}
+				a.method = &argv[i]
+			case "--modifier":
+				name, _, ok := strings.Cut(argv[i], "=")
+				if !ok || !validModifierName(name) {
+					fail(errOut, "invalid --modifier: \"%s\", use name=command",
+						argv[i])
+					return a, true, exitUsage
+				}
+				a.modifiers = append(a.modifiers, argv[i])
+			case "--append", "--insert":
+				t := edit{keypath: argv[i], append: arg == "--append",
+					insert: arg == "--insert"}
+				switch {
+				case a.target != nil:
+					fail(errOut, "--append and --insert require -v or -V")
+					return a, true, exitUsage
+				case a.pending < len(a.edits) && a.edits[a.pending].del:
+					fail(errOut, "missing keypath for -D")
+					return a, true, exitUsage
+				case a.pending < len(a.edits):
+					// the value was given first, like "-v x --append items"
+					e := &a.edits[a.pending]
+					e.keypath = t.keypath
+					e.append = e.append || t.append
+					e.insert = t.insert
+					a.pending++
+				default:
+					a.target = &t
+				}
+			case "--max-unstring-depth":
+				n, err := strconv.Atoi(argv[i])
+				if err != nil || n < 0 {
+					fail(errOut, "invalid --max-unstring-depth: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+				a.unstrmax = n
+			case "--order":
+				if argv[i] != "dfs" && argv[i] != "bfs" {
+					fail(errOut, "invalid --order: \"%s\"", argv[i])
+					return a, true, exitUsage
+				}
+				a.order = argv[i]
+			}
+		case "--strict":
+			a.strict = true
+		case "--exists":
+			a.test = true
+		case "--exit-status":
+			a.exists = true
+		case "--sort-keys":
+			a.prettyOptions().SortKeys = true
+		case "--watch":
+			a.watch = true
+		case "--csv":
+			a.csv = ','
+		case "--tsv":
+			a.csv = '\t'
+		case "--stream":
+			a.multi = true
+		case "--validate":
+			a.validate = true
+		case "--diff":
+			a.diff = true
+		case "--backup":
+			a.backup = true
+		case "--desc":
+			a.desc = true
+		case "--all":
+			a.all = true
+		case "--paths":
+			a.paths = true
+		case "--flatten":
+			a.flatten = true
+		case "--unflatten":
+			a.unflatten = true
+		case "--slurp":
+			a.slurp = true
+		case "--export":
+			a.export = true
+		case "--env-subst":
+			a.envsubst = true
+		case "--mmap":
+			a.mmap = true
+		case "--jsonc", "--json5":
+			a.from = argv[i][2:]
+		case "--literal-path":
+			a.literal = true
+		case "--concat":
+			a.concat = true
+		case "--merge":
+			a.mergedocs = true
+		case "--join-output":
+			a.join = true
+		case "--raw-output0":
+			a.nulend = true
+		case "--explode":
+			a.explode = true
+		case "--deep-unstring":
+			a.unstring = true
+		case "--size":
+			a.size = true
+		case "--stats":
+			a.stats = true
+		case "--normalize-ws":
+			a.normws = true
+		case "--explain-query":
+			a.explainq = true
+		case "--preserve-number-format":
+			a.keepnum = true
+		case "--unquote-keys":
+			a.unquote = true
+		case "--infer-schema":
+			a.infer = true
+		case "--omit-null":
+			a.omitnull = true
+		case "--omit-null-arrays":
+			a.omitnull = true
+			a.omitnulla = true
+		case "--move":
+			if i+2 >= len(argv) {
+				fail(errOut, "argument missing after: \"%s\"", argv[i])
+				return a, true, exitUsage
 			}
+			if a.pending < len(a.edits) || a.target != nil {
+				fail(errOut, "--move cannot be given before the keypath or "+
+					"value of another edit")
+				return a, true, exitUsage
+			}
+			a.edits = append(a.edits, edit{move: true, from: argv[i+1],
+				keypath: argv[i+2]})
+			a.pending++
+			i += 2
+		case "--eq-paths":
+			if i+2 >= len(argv) {
+				fail(errOut, "argument missing after: \"%s\"", argv[i])
+				return a, true, exitUsage
+			}
+			a.eqpaths = argv[i+1 : i+3]
+			i += 2
 		case "--force-notty":
 			a.notty = true
 		case "--version":
-			fmt.Fprintf(os.Stdout, "%s\n", tag)
-			return a, false, 0
+			fmt.Fprintf(out, "%s\n", tag)
+			return a, true, 0
 		case "-h", "--help", "-?":
-			help()
+			help(out)
 			return a, true, 0
 		}
 	}
-	if !a.keypathok && !a.pretty && !a.ugly {
-		fail("missing required option: \"keypath\"")
-		return a, true, 1
+	if a.target != nil {
+		fail(errOut, "--append and --insert require -v or -V")
+		return a, true, exitUsage
+	}
+	if a.force != 0 {
+		// a trailing -s, -N, or --type applies to the last edit, like
+		// "-v 1.0 v -s"
+		if len(a.edits) == 0 || a.edits[len(a.edits)-1].del ||
+			a.edits[len(a.edits)-1].move {
+			fail(errOut, "%s requires -v, -V, or -a", forceFlag(a.force))
+			return a, true, exitUsage
+		}
+		last := &a.edits[len(a.edits)-1]
+		if last.force != 0 && last.force != a.force {
+			fail(errOut, "-s, -N, and --type cannot be used together")
+			return a, true, exitUsage
+		}
+		last.force = a.force
+	}
+	if a.pending < len(a.edits) {
+		if a.pending == len(a.edits)-1 && a.keypathok {
+			// the keypath was given before the -v, like "jj name -v Tom"
+			a.edits[a.pending].keypath = a.keypath
+			a.keypathok = false
+		} else {
+			if a.edits[a.pending].del {
+				fail(errOut, "missing keypath for -D")
+			} else {
+				fail(errOut, "missing keypath for %s \"%s\"",
+					a.edits[a.pending].option(), a.edits[a.pending].value)
+			}
+			return a, true, exitUsage
+		}
+	} else if len(a.edits) > 0 && a.keypathok {
+		fail(errOut, "unknown option argument: \"%s\"", a.keypath)
+		return a, true, exitUsage
+	}
+	if (a.flatten || a.unflatten) && (len(a.edits) > 0 ||
+		(a.flatten && a.unflatten)) {
+		fail(errOut, "--flatten and --unflatten cannot be used together or "+
+			"with edits")
+		return a, true, exitUsage
+	}
+	if a.mmap && (len(a.infiles) != 1 || a.mergedocs || isURL(a.infiles[0])) {
+		fail(errOut, "--mmap requires a single -i file")
+		return a, true, exitUsage
+	}
+	if a.sep != nil && !a.literal {
+		fail(errOut, "--sep requires --literal-path")
+		return a, true, exitUsage
+	}
+	if a.literal {
+		sep := "."
+		if a.sep != nil {
+			sep = *a.sep
+		}
+		if sep == "" {
+			fail(errOut, "--sep cannot be empty")
+			return a, true, exitUsage
+		}
+		if a.command == "diff" || a.command == "cmp" {
+			// the first two are the files to compare
+			for i := 1; i < len(a.keypaths); i++ {
+				a.keypaths[i] = literalPath(a.keypaths[i], sep)
+			}
+		} else {
+			a.keypath = literalPath(a.keypath, sep)
+			for i := range a.keypaths {
+				a.keypaths[i] = literalPath(a.keypaths[i], sep)
+			}
+		}
+		for i := range a.edits {
+			a.edits[i].keypath = literalPath(a.edits[i].keypath, sep)
+			a.edits[i].from = literalPath(a.edits[i].from, sep)
+		}
+		if a.sortpath != nil {
+			*a.sortpath = literalPath(*a.sortpath, sep)
+		}
+		for i := range a.eqpaths {
+			a.eqpaths[i] = literalPath(a.eqpaths[i], sep)
+		}
+	}
+	if len(a.keypaths) > 0 && (len(a.edits) > 0 || a.explainq ||
+		a.sortby != nil || a.findvalue != nil || a.findkey != nil ||
+		a.replnull != nil ||
+		a.normws || a.paths || a.flatten || a.unflatten || a.infer ||
+		a.size || a.stats || a.unstring) {
+		fail(errOut, "unknown option argument: \"%s\"", a.keypaths[0])
+		return a, true, exitUsage
+	}
+	if a.test {
+		// a -v is the value to compare with rather than an edit
+		if len(a.edits) > 1 || (len(a.edits) == 1 && (a.edits[0].del ||
+			a.edits[0].append || a.edits[0].file || a.edits[0].insert ||
+			a.edits[0].move)) || a.merge {
+			fail(errOut, "--exists only takes a single -v value to "+
+				"compare with")
+			return a, true, exitUsage
+		}
+		if len(a.edits) == 1 {
+			if a.edits[0].force != 0 && a.raw {
+				fail(errOut, "-r cannot be used with -s, -N, or --type")
+				return a, true, exitUsage
+			}
+			a.expect = &a.edits[0]
+			a.expect.raw = a.raw
+			a.keypath, a.keypathok = a.expect.keypath, true
+			a.edits = nil
+		}
+		if !a.keypathok || len(a.keypaths) > 0 || a.ndjson || a.multi ||
+			a.command != "" {
+			fail(errOut, "--exists requires a single keypath, and cannot "+
+				"be used with -J, -M, or a command")
+			return a, true, exitUsage
+		}
+	}
+	var values, files, appends, stdin int
+	for _, e := range a.edits {
+		if e.force != 0 && a.raw {
+			fail(errOut, "-r cannot be used with -s, -N, or --type")
+			return a, true, exitUsage
+		}
+		if e.file && e.value == "-" {
+			stdin++
+		}
+		if e.file && !e.at {
+			files++
+		} else if e.append {
+			appends++
+		} else if !e.del && !e.move {
+			values++
+		}
+	}
+	if a.merge && (values+files == 0 || appends > 0) {
+		fail(errOut, "-m requires -v or -V, and cannot be used with -a")
+		return a, true, exitUsage
+	}
+	if values > 0 && files > 0 {
+		fail(errOut, "-v and -V cannot be used together")
+		return a, true, exitUsage
+	}
+	if stdin > 1 || (stdin > 0 && len(a.infiles) == 0) {
+		fail(errOut, "-V - and -v @- require the document to be read with -i")
+		return a, true, exitUsage
+	}
+	for i := range a.edits {
+		a.edits[i].raw = a.raw
+		if a.edits[i].append && a.edits[i].keypath != "-1" &&
+			!strings.HasSuffix(a.edits[i].keypath, ".-1") {
+			a.edits[i].keypath += ".-1"
+		}
+	}
+	if a.inplace && a.each == nil {
+		if len(a.infiles) != 1 {
+			fail(errOut, "-I requires a single input file given with -i")
+			return a, true, exitUsage
+		}
+		if a.outfile != nil {
+			fail(errOut, "-I cannot be used with -o")
+			return a, true, exitUsage
+		}
+		a.outfile = &a.infiles[0]
+	} else if a.backup && !a.inplace {
+		fail(errOut, "--backup requires -I")
+		return a, true, exitUsage
+	}
+	if a.backup && a.outfile != nil && isURL(*a.outfile) {
+		fail(errOut, "--backup cannot be used with a URL")
+		return a, true, exitUsage
+	}
+	if a.method != nil && (a.outfile == nil || !isURL(*a.outfile)) {
+		fail(errOut, "--method requires -o or -I with a URL")
+		return a, true, exitUsage
+	}
+	if a.concat && a.mergedocs {
+		fail(errOut, "--concat and --merge cannot be used together")
+		return a, true, exitUsage
+	}
+	if (a.concat || a.mergedocs) && (a.ndjson || a.multi || a.slurp ||
+		a.validate) {
+		fail(errOut, "--concat and --merge cannot be used with -J, -M, "+
+			"--slurp, or --validate")
+		return a, true, exitUsage
+	}
+	if len(a.infiles) > 1 && !a.mergedocs && !a.slurp && !a.ndjson &&
+		!a.validate && a.each == nil {
+		// several files are several documents, which would be lost if
+		// they were read as one
+		a.concat = true
+	}
+	if a.concat {
+		// the documents are processed one by one, like with -M
+		a.multi = true
+	}
+	if a.from == "" && len(a.infiles) > 0 && !a.mergedocs && !a.concat {
+		// with --merge and --concat each file has its own format
+		a.from = formatOf(a.infiles[0])
+	}
+	if a.to == "" && a.outfile != nil {
+		a.to = formatOf(*a.outfile)
+	}
+	if a.from != "" && a.from != "json" && !a.concat && ((len(a.infiles) > 1 &&
+		!a.mergedocs) ||
+		a.ndjson || a.multi || a.slurp || a.validate) {
+		fail(errOut, "%s input is a single document, it cannot be used "+
+			"with several -i, -J, -M, --slurp, or --validate", a.from)
+		return a, true, exitUsage
+	}
+	if a.to != "" && a.to != "json" && (a.ndjson || a.multi) {
+		fail(errOut, "%s output cannot be used with -J or -M", a.to)
+		return a, true, exitUsage
+	}
+	if a.popts != nil && !a.ugly {
+		a.pretty = true
+	}
+	if a.typeof && (len(a.edits) > 0 || a.del) {
+		fail(errOut, "-t cannot be used with -v, -a, or -D")
+		return a, true, exitUsage
+	}
+	switch {
+	case a.command != "patch" && a.patchfile != nil:
+		fail(errOut, "-f is only used with the patch command")
+		return a, true, exitUsage
+	case a.command == "patch" && a.patchfile == nil:
+		fail(errOut, "missing required option for patch: \"-f\"")
+		return a, true, exitUsage
+	case a.command == "patch" && *a.patchfile == "-" && len(a.infiles) == 0:
+		fail(errOut, "-f - requires the document to be read with -i")
+		return a, true, exitUsage
+	case a.command == "patch" && (a.keypathok || len(a.edits) > 0):
+		fail(errOut, "patch cannot be used with a keypath or edits")
+		return a, true, exitUsage
+	case a.command == "diff" && (len(a.keypaths) != 1 || len(a.edits) > 0 ||
+		len(a.infiles) > 0):
+		fail(errOut, "diff requires two files")
+		return a, true, exitUsage
+	case a.command == "cmp" && (len(a.keypaths) < 1 || len(a.keypaths) > 2 ||
+		len(a.edits) > 0 || len(a.infiles) > 0):
+		fail(errOut, "cmp requires two files and an optional keypath")
+		return a, true, exitUsage
+	case (a.command == "diff" || a.command == "cmp") && a.keypath == "-" &&
+		a.keypaths[0] == "-":
+		fail(errOut, "%s can only read one of the files from stdin", a.command)
+		return a, true, exitUsage
+	case a.command != "validate" && a.schemafile != nil:
+		fail(errOut, "--schema is only used with the validate command")
+		return a, true, exitUsage
+	case a.command == "validate" && a.schemafile == nil:
+		fail(errOut, "missing required option for validate: \"-s\"")
+		return a, true, exitUsage
+	case a.command == "validate" && *a.schemafile == "-" && len(a.infiles) == 0:
+		fail(errOut, "-s - requires the document to be read with -i")
+		return a, true, exitUsage
+	case a.command == "validate" && (a.keypathok || len(a.edits) > 0 ||
+		a.ndjson || a.multi || a.validate):
+		fail(errOut, "validate cannot be used with a keypath, edits, -J, -M, "+
+			"or --validate")
+		return a, true, exitUsage
+	}
+	if a.mpatchfile != nil {
+		if a.keypathok || len(a.edits) > 0 || a.command != "" {
+			fail(errOut, "--merge-patch cannot be used with a keypath, "+
+				"edits, or a command")
+			return a, true, exitUsage
+		}
+		if *a.mpatchfile == "-" && len(a.infiles) == 0 {
+			fail(errOut, "--merge-patch - requires the document to be "+
+				"read with -i")
+			return a, true, exitUsage
+		}
+	}
+	if a.validate && (len(a.edits) > 0 || a.del) {
+		fail(errOut, "--validate cannot be used with -v, -V, -a, or -D")
+		return a, true, exitUsage
+	}
+	if a.validate && (a.keypathok || a.multi || a.slurp) {
+		fail(errOut, "--validate does not take a keypath, -M, or --slurp")
+		return a, true, exitUsage
+	}
+	if a.diff && ((len(a.edits) == 0 && a.command != "patch" &&
+		a.mpatchfile == nil) || a.ndjson || a.multi) {
+		fail(errOut, "--diff requires -v, -V, -a, -D, --merge-patch, or "+
+			"patch, and cannot be used with -J or -M")
+		return a, true, exitUsage
+	}
+	if a.csv != 0 && ((a.to != "" && a.to != "json") || a.ndjson ||
+		a.multi || a.diff || a.lines || a.typeof) {
+		fail(errOut, "-c and --tsv cannot be used with --to, -J, -M, "+
+			"--diff, -l, or -t")
+		return a, true, exitUsage
+	}
+	if a.repl && (a.keypathok || len(a.edits) > 0 ||
+		a.outfile != nil || a.ndjson || a.multi || a.watch ||
+		a.command != "") {
+		fail(errOut, "-x does not take a keypath, edits, -o, -I, -J, -M, "+
+			"-w, or a command")
+		return a, true, exitUsage
+	}
+	if a.watch && (len(a.infiles) != 1 || isURL(a.infiles[0]) ||
+		a.inplace || stdin > 0 || a.ndjson || a.multi || a.command != "" ||
+		a.mpatchfile != nil) {
+		fail(errOut, "-w requires a single -i file that is not a URL, and "+
+			"cannot be used with -I, -J, -M, stdin values, or a command")
+		return a, true, exitUsage
+	}
+	if a.each != nil && (len(a.infiles) > 0 || a.outfile != nil ||
+		a.watch || a.repl || a.mmap || a.mergedocs || a.command != "" ||
+		stdin > 0) {
+		fail(errOut, "--each cannot be used with -i, -o, -w, -x, --mmap, "+
+			"--merge, stdin values, or a command")
+		return a, true, exitUsage
+	}
+	if a.jobs != 0 && a.each == nil {
+		fail(errOut, "--jobs requires --each")
+		return a, true, exitUsage
+	}
+	if a.export && (a.csv != 0 || (a.to != "" && a.to != "json") ||
+		a.ndjson || a.multi || a.diff || a.lines || a.typeof || a.explode) {
+		fail(errOut, "--export cannot be used with -c, --tsv, --to, -J, "+
+			"-M, --diff, -l, -t, or --explode")
+		return a, true, exitUsage
+	}
+	if a.tmpl != nil && (a.csv != 0 || a.export || (a.to != "" &&
+		a.to != "json") || a.diff || a.lines || a.typeof || a.explode) {
+		fail(errOut, "--template cannot be used with -c, --tsv, --export, "+
+			"--to, --diff, -l, -t, or --explode")
+		return a, true, exitUsage
+	}
+	if a.envsubst && (a.ndjson || a.multi) {
+		fail(errOut, "--env-subst cannot be used with -J or -M")
+		return a, true, exitUsage
+	}
+	if a.explode && (a.csv != 0 || (a.to != "" && a.to != "json") ||
+		a.ndjson || a.multi || a.diff || a.lines) {
+		fail(errOut, "--explode cannot be used with -c, --tsv, --to, -J, "+
+			"-M, --diff, or -l")
+		return a, true, exitUsage
+	}
+	if a.nul && !a.lines {
+		fail(errOut, "-0 requires -l")
+		return a, true, exitUsage
+	}
+	if a.ndjson && a.multi {
+		fail(errOut, "-J cannot be used with -M")
+		return a, true, exitUsage
+	}
+	if (a.ndjson || a.multi) && a.slurp {
+		fail(errOut, "-J and -M cannot be used with --slurp")
+		return a, true, exitUsage
+	}
+	if a.sortpath != nil && (a.keypathok || len(a.edits) > 0) {
+		fail(errOut, "--sort takes the keypath of the array to sort, and "+
+			"cannot be used with a keypath or edits")
+		return a, true, exitUsage
+	}
+	if (a.replnull != nil || a.normws) && a.keypathok {
+		fail(errOut, "unexpected keypath, use --path-prefix to limit the changes")
+		return a, true, exitUsage
+	}
+	if !a.keypathok && len(a.edits) == 0 && !a.pretty && !a.ugly &&
+		a.sortby == nil && a.sortpath == nil &&
+		a.findvalue == nil && a.findkey == nil && a.replnull == nil &&
+		!a.paths && !a.flatten && !a.unflatten &&
+		!a.infer && a.mergekeys == nil && !a.normws &&
+		!a.size && !a.stats && !a.unstring && a.eqpaths == nil &&
+		!a.validate && !a.export &&
+		a.command == "" && a.mpatchfile == nil && !a.repl {
+		fail(errOut, "missing required option: \"keypath\"")
+		return a, true, exitUsage
 	}
 	return a, false, 0
 }
 
-func JJMain() (int, error) {
-	a, shouldExit, exitCode := parseArgs()
-	if shouldExit {
-		return exitCode, nil
+// result is the outcome of applying the requested operation to a single
+// document, before any output formatting takes place.
+type result struct {
+	data    []byte
+	typ     gjson.Type
+	array   bool
+	missing bool   // the keypath being read or deleted does not exist
+	text    bool   // data is not JSON, like YAML output, and is written as is
+	diag    string // diagnostic message for stderr
+}
+
+// autoRaw reports whether a -v value should be set as a raw JSON literal
+// rather than as a string.
+func autoRaw(val string) bool {
+	switch val {
+	case "true", "false", "null":
+		return true
 	}
-	var input []byte
-	var err error
-	var outb []byte
-	var outs string
-	var outa bool
-	var outt gjson.Type
-	var f *os.File
-	if a.infile == nil {
-		input, err = io.ReadAll(os.Stdin)
-	} else {
-		input, err = os.ReadFile(*a.infile)
+	integer, ok := scanNumber(val)
+	if !ok {
+		return false
 	}
-	if err != nil {
-		goto fail
+	if integer {
+		// integers are kept as written, however large
+		return true
 	}
-	if a.del {
-		outb, err = sjson.DeleteBytes(input, a.keypath)
+	// other numbers must not overflow, like 1e309 does
+	_, err := strconv.ParseFloat(val, 64)
+	return err == nil
+}
+
+// testValue reports whether the value at the keypath exists and, when
+// --exists is given a -v value, whether it is equal to that value.
+func testValue(a args, input []byte) (bool, error) {
+	v := gjson.GetBytes(input, a.keypath)
+	if !v.Exists() || a.expect == nil {
+		return v.Exists(), nil
+	}
+	e := a.expect
+	var raw []byte
+	switch {
+	case e.cast():
+		var err error
+		if raw, err = castValue(*e); err != nil {
+			return false, err
+		}
+	case e.force == 'N' && !isLiteral(e.value):
+		return false, fmt.Errorf("value \"%s\" is not a number, true, "+
+			"false, or null", e.value)
+	case e.raw || e.force == 'N' || (e.force == 0 && autoRaw(e.value)):
+		raw = []byte(e.value)
+		if !gjson.ValidBytes(raw) {
+			return false, fmt.Errorf("value \"%s\" is not valid JSON", e.value)
+		}
+	default:
+		raw = appendJSONString(nil, e.value)
+	}
+	return jsonEqual(v, gjson.ParseBytes(raw)), nil
+}
+
+// typeForces maps the types of --type to the force of the edits they apply
+// to, where a string is the same as -s.
+var typeForces = map[string]byte{
+	"string": 's',
+	"number": 'n',
+	"bool":   'b',
+	"null":   'z',
+	"json":   'j',
+}
+
+// forceFlag returns the option that forces the type of an edit.
+func forceFlag(force byte) string {
+	switch force {
+	case 's', 'N':
+		return "-" + string(force)
+	}
+	return "--type"
+}
+
+// cast reports whether the value is converted to a type given with --type,
+// other than string.
+func (e edit) cast() bool {
+	return e.force != 0 && strings.IndexByte("nbzj", e.force) >= 0
+}
+
+// castValue converts the value of an edit to the type given with --type,
+// returning it as raw JSON. A number may be written in any way that Go
+// parses, like "+1" or "01", and is kept as written when it's already
+// valid JSON, like "1.0". It must be finite, so 1e999 or NaN is not a
+// number. A bool may be 1, t, true, 0, f, false, and the like, and a null
+// must be empty or null.
+func castValue(e edit) ([]byte, error) {
+	val := strings.TrimSpace(e.value)
+	switch e.force {
+	case 'n':
+		f, err := strconv.ParseFloat(val, 64)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			// like 1e999, which is too large for any JSON reader
+			return nil, fmt.Errorf("value \"%s\" is not a finite number",
+				e.value)
+		}
+		if _, ok := scanNumber(val); ok {
+			return []byte(val), nil
+		}
 		if err != nil {
-			goto fail
+			return nil, fmt.Errorf("value \"%s\" is not a number", e.value)
 		}
-	} else if a.value != nil {
-		raw := a.raw
-		val := *a.value
-		if !raw {
-			switch val {
-			default:
-				if len(val) > 0 {
-					if (val[0] >= '0' && val[0] <= '9') || val[0] == '-' {
-						if _, err := strconv.ParseFloat(val, 64); err == nil {
-							raw = true
-						}
-					}
-				}
-			case "true", "false", "null":
-				raw = true
-			}
+		return strconv.AppendFloat(nil, f, 'g', -1, 64), nil
+	case 'b':
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("value \"%s\" is not a bool", e.value)
+		}
+		return strconv.AppendBool(nil, b), nil
+	case 'z':
+		if val != "" && val != "null" {
+			return nil, fmt.Errorf("value \"%s\" is not null", e.value)
+		}
+		return []byte("null"), nil
+	}
+	if !gjson.Valid(e.value) {
+		return nil, fmt.Errorf("value \"%s\" is not valid JSON", e.value)
+	}
+	return []byte(e.value), nil
+}
+
+// isLiteral reports whether val is a JSON number, true, false, or null.
+func isLiteral(val string) bool {
+	switch val {
+	case "true", "false", "null":
+		return true
+	}
+	_, ok := scanNumber(val)
+	return ok
+}
+
+// scanNumber reports whether s is a number as written in JSON, with no
+// leading zeros, and whether it is an integer.
+func scanNumber(s string) (integer, ok bool) {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	digits := func() int {
+		n := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+			n++
+		}
+		return n
+	}
+	if i < len(s) && s[i] == '0' {
+		i++
+	} else if digits() == 0 {
+		return false, false
+	}
+	integer = true
+	if i < len(s) && s[i] == '.' {
+		i++
+		if digits() == 0 {
+			return false, false
+		}
+		integer = false
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if digits() == 0 {
+			return false, false
+		}
+		integer = false
+	}
+	return integer, i == len(s)
+}
+
+// typeName returns the name of the JSON type of v, as output with -t.
+func typeName(v gjson.Result) string {
+	switch {
+	case !v.Exists():
+		return "null-missing"
+	case v.IsArray():
+		return "array"
+	case v.IsObject():
+		return "object"
+	case v.Type == gjson.String:
+		return "string"
+	case v.Type == gjson.Number:
+		return "number"
+	case v.Type == gjson.True:
+		return "true"
+	case v.Type == gjson.False:
+		return "false"
+	}
+	return "null"
+}
+
+// editValue returns the value of an edit as raw JSON.
+func editValue(e edit) ([]byte, error) {
+	switch {
+	case e.cast():
+		return castValue(e)
+	case e.force == 'N' && !isLiteral(e.value):
+		return nil, fmt.Errorf("value \"%s\" is not a number, true, false, "+
+			"or null", e.value)
+	case e.raw || e.force == 'N' ||
+		(e.force == 0 && !e.file && autoRaw(e.value)):
+		if !gjson.Valid(e.value) {
+			return nil, fmt.Errorf("value \"%s\" is not valid JSON", e.value)
+		}
+		return []byte(e.value), nil
+	}
+	return appendJSONString(nil, e.value), nil
+}
+
+// insertAt inserts raw into the array at keypath when its last component is
+// an index of the array, moving the later elements up. Otherwise the value
+// is set at keypath, unless the insert is required.
+func insertAt(data []byte, keypath string, raw []byte, opts *sjson.Options,
+	required bool) ([]byte, error) {
+	segs := splitQuery(keypath)
+	last := segs[len(segs)-1]
+	var parent string
+	if len(segs) > 1 {
+		parent = keypath[:len(keypath)-len(last.text)-1]
+	}
+	arr := gjson.ParseBytes(data)
+	if parent != "" {
+		arr = gjson.GetBytes(data, parent)
+	}
+	if !arr.IsArray() || !isIndex(last.text) || last.sep == '|' {
+		if required {
+			return nil, errors.New("not an index of an array")
 		}
+		return sjson.SetRawBytesOptions(data, keypath, raw, opts)
+	}
+	i, _ := strconv.Atoi(last.text)
+	if n := len(arr.Array()); i > n {
+		return nil, fmt.Errorf("index %d is out of range, the array has %d "+
+			"elements", i, n)
+	}
+	if parent == "" {
+		return insertValue(arr, i, raw), nil
+	}
+	return sjson.SetRawBytesOptions(data, parent, insertValue(arr, i, raw), opts)
+}
+
+// applyEdit applies a single edit to data, and reports whether the value to
+// delete was missing.
+func applyEdit(a args, data []byte, e edit, opts *sjson.Options) ([]byte,
+	bool, error) {
+	if parent, ok := strings.CutSuffix(e.keypath, ".-1"); ok && !e.del &&
+		!e.move && !e.insert {
+		// sjson would append to anything else as an object with a "-1" key
+		if v := gjson.GetBytes(data, parent); v.Exists() && !v.IsArray() {
+			return nil, false, errors.New("value to append to is not an array")
+		}
+	}
+	switch {
+	case e.del:
+		deleted, err := sjson.DeleteBytes(data, e.keypath)
+		return deleted, err == nil && bytes.Equal(deleted, data), err
+	case e.move:
+		if e.keypath == e.from || strings.HasPrefix(e.keypath, e.from+".") {
+			return nil, false, fmt.Errorf("cannot move \"%s\" into itself",
+				e.from)
+		}
+		v := gjson.GetBytes(data, e.from)
+		if !v.Exists() {
+			return nil, false, fmt.Errorf("path does not exist: \"%s\"",
+				e.from)
+		}
+		data, err := sjson.DeleteBytes(data, e.from)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err = insertAt(data, e.keypath, []byte(v.Raw), opts, false)
+		return data, false, err
+	case e.insert:
+		raw, err := editValue(e)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err = insertAt(data, e.keypath, raw, opts, true)
+		return data, false, err
+	case a.merge:
+		raw, err := mergeInto(data, e.keypath, e.value)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err = sjson.SetRawBytesOptions(data, e.keypath, raw, opts)
+		return data, false, err
+	case e.cast():
+		raw, err := castValue(e)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err = sjson.SetRawBytesOptions(data, e.keypath, raw, opts)
+		return data, false, err
+	case e.force == 'N' && !isLiteral(e.value):
+		return nil, false, fmt.Errorf("value \"%s\" is not a number, true, "+
+			"false, or null", e.value)
+	case e.raw || e.force == 'N' ||
+		(e.force == 0 && !e.file && autoRaw(e.value)):
+		// set as raw block
+		data, err := sjson.SetRawBytesOptions(data, e.keypath,
+			[]byte(e.value), opts)
+		return data, false, err
+	}
+	// set as a string
+	data, err := sjson.SetBytesOptions(data, e.keypath, e.value, opts)
+	return data, false, err
+}
+
+// process performs the operation on the input document, stopping between
+// two edits when ctx is done. It fails when a modifier command fails.
+func process(ctx context.Context, a args, input []byte) (res result, err error) {
+	takeModifierError()
+	defer func() {
+		if merr := takeModifierError(); err == nil && merr != nil {
+			res, err = result{}, merr
+		}
+	}()
+	if a.patch != nil {
+		res.data, err = ApplyPatch(input, a.patch)
+		if err != nil {
+			return res, err
+		}
+	} else if a.mpatch != nil {
+		res.data, err = MergePatch(input, a.mpatch)
+		if err != nil {
+			return res, err
+		}
+	} else if len(a.edits) > 0 {
 		opts := &sjson.Options{}
 		if a.opt {
 			opts.Optimistic = true
 			opts.ReplaceInPlace = true
 		}
-		if raw {
-			// set as raw block
-			outb, err = sjson.SetRawBytesOptions(
-				input, a.keypath, []byte(val), opts)
+		res.data = input
+		for _, e := range a.edits {
+			paths := []string{e.keypath}
+			if isMultiPath(e.keypath) && !e.move {
+				paths = expandPath(res.data, e.keypath)
+				if e.del {
+					// only the values that exist, which are missing when
+					// there are none
+					paths = existingPaths(res.data, paths)
+					res.missing = res.missing || len(paths) == 0
+				}
+			}
+			for i := range paths {
+				if err := ctx.Err(); err != nil {
+					return res, err
+				}
+				if e.del {
+					// delete the last of the elements first, so that the
+					// indexes of the others stay the same
+					e.keypath = paths[len(paths)-1-i]
+				} else {
+					e.keypath = paths[i]
+				}
+				var missing bool
+				res.data, missing, err = applyEdit(a, res.data, e, opts)
+				if err != nil {
+					return res, fmt.Errorf("keypath \"%s\": %v", e.keypath, err)
+				}
+				res.missing = res.missing || missing
+			}
+		}
+	} else if a.replnull != nil {
+		val := *a.replnull
+		var n int
+		res.data, n, err = replaceNulls(input, a.prefix, val,
+			a.raw || autoRaw(val))
+		if err != nil {
+			return res, err
+		}
+		res.diag = fmt.Sprintf("null values replaced: %d", n)
+	} else if a.normws {
+		res.data, err = normalizeStrings(input, a.prefix)
+		if err != nil {
+			return res, err
+		}
+	} else if a.sortpath != nil {
+		var field string
+		if a.sortby != nil {
+			field = *a.sortby
+		}
+		raw, err := sortArray(gjson.GetBytes(input, *a.sortpath), field, a.desc)
+		if err != nil {
+			return res, fmt.Errorf("keypath \"%s\": %v", *a.sortpath, err)
+		}
+		res.data, err = sjson.SetRawBytes(input, *a.sortpath, raw)
+		if err != nil {
+			return res, err
+		}
+	} else if a.sortby != nil {
+		v := gjson.ParseBytes(input)
+		if a.keypathok {
+			v = gjson.GetBytes(input, a.keypath)
+		}
+		res.data, err = sortArray(v, *a.sortby, a.desc)
+		if err != nil {
+			return res, err
+		}
+		res.typ = gjson.JSON
+		res.array = true
+	} else if a.unstring {
+		v := gjson.ParseBytes(input)
+		if a.keypathok {
+			v = gjson.GetBytes(input, a.keypath)
+		}
+		res.data = deepUnstring(v, a.unstrmax)
+		res.typ = gjson.JSON
+		res.array = gjson.ParseBytes(res.data).IsArray()
+	} else if a.size {
+		n := len(input)
+		if a.keypathok {
+			n = len(gjson.GetBytes(input, a.keypath).Raw)
+		}
+		res.data = []byte(fmt.Sprintf(`{"value_bytes":%d,"doc_bytes":%d}`,
+			n, len(input)))
+		res.typ = gjson.JSON
+	} else if a.stats {
+		v := gjson.ParseBytes(input)
+		if a.keypathok {
+			v = gjson.GetBytes(input, a.keypath)
+		}
+		res.data = valueStats(v)
+		res.typ = gjson.JSON
+	} else if a.mergekeys != nil {
+		res.data, err = mergeKeys(input, splitList(*a.mergekeys))
+		if err != nil {
+			return res, err
+		}
+		res.typ = gjson.JSON
+	} else if a.infer {
+		v := gjson.ParseBytes(input)
+		if a.keypathok {
+			v = gjson.GetBytes(input, a.keypath)
+		}
+		res.data = inferSchema(v)
+		res.typ = gjson.JSON
+	} else if a.flatten || a.unflatten {
+		v := gjson.ParseBytes(input)
+		if a.keypathok {
+			v = gjson.GetBytes(input, a.keypath)
+		}
+		if a.flatten {
+			res.data, err = flatten(v, a.order)
 		} else {
-			// set as a string
-			outb, err = sjson.SetBytesOptions(input, a.keypath, val, opts)
+			res.data, err = unflatten(v)
 		}
 		if err != nil {
-			goto fail
+			return res, err
+		}
+		res.typ = gjson.JSON
+		res.array = gjson.ParseBytes(res.data).IsArray()
+	} else if a.paths {
+		v := gjson.ParseBytes(input)
+		var path string
+		if a.keypathok {
+			v = gjson.GetBytes(input, a.keypath)
+			path = a.keypath
+		}
+		res.data = appendStrings(nil, leafPaths(v, path, a.order))
+		res.typ = gjson.JSON
+		res.array = true
+	} else if a.findvalue != nil {
+		v := gjson.ParseBytes(input)
+		if a.keypathok {
+			v = gjson.GetBytes(input, a.keypath)
+		}
+		var path string
+		if a.keypathok {
+			path = a.keypath
+		}
+		paths := findValue(v, path, valueMatcher(*a.findvalue, a.raw), a.all)
+		res.missing = len(paths) == 0
+		if a.all {
+			res.data = appendStrings(nil, paths)
+			res.typ = gjson.JSON
+			res.array = true
+		} else {
+			res.typ = gjson.String
+			if len(paths) > 0 {
+				res.data = []byte(paths[0])
+			}
 		}
+	} else if a.findkey != nil {
+		v := gjson.ParseBytes(input)
+		var path string
+		if a.keypathok {
+			v = gjson.GetBytes(input, a.keypath)
+			path = a.keypath
+		}
+		res.data = findKey(v, path, *a.findkey, a.order)
+		res.typ = gjson.JSON
+		res.array = true
+	} else if len(a.keypaths) > 0 || ((a.keyed || a.asarray) && a.keypathok) {
+		paths := append([]string{a.keypath}, a.keypaths...)
+		res.data, res.missing = getPaths(input, paths, a.keyed, a.typeof)
+		res.typ = gjson.JSON
+		res.array = !a.keyed
 	} else {
 		if !a.keypathok {
-			outb = input
+			res.data = input
 		} else {
-			res := gjson.GetBytes(input, a.keypath)
-			if a.raw {
-				outs = res.Raw
+			v := gjson.GetBytes(input, a.keypath)
+			res.missing = !v.Exists()
+			if a.typeof {
+				res.data = []byte(typeName(v))
+				res.typ = gjson.String
+			} else if a.raw || a.wrap != nil {
+				res.data = []byte(v.Raw)
 			} else {
-				outt = res.Type
-				outa = res.IsArray()
-				outs = res.String()
+				res.typ = v.Type
+				res.array = v.IsArray()
+				if v.Type == gjson.Number && a.keepnum {
+					res.data = []byte(v.Raw)
+				} else {
+					res.data = []byte(v.String())
+				}
 			}
 		}
-
 	}
-	if a.outfile == nil {
-		f = os.Stdout
-	} else {
-		f, err = os.Create(*a.outfile)
+	if a.wrap != nil {
+		raw := bytes.TrimSpace(res.data)
+		if res.typ == gjson.String {
+			raw = appendJSONString(nil, string(res.data))
+		} else if len(raw) == 0 {
+			raw = []byte("null")
+		}
+		res.data, err = sjson.SetRawBytes([]byte("{}"), escapeKey(*a.wrap), raw)
 		if err != nil {
-			goto fail
+			return res, err
 		}
+		res.typ = gjson.JSON
+		res.array = false
 	}
-	if outb == nil {
-		outb = []byte(outs)
+	return res, nil
+}
+
+// runContext runs fn in its own goroutine and returns the context error as
+// soon as ctx is done, without waiting for fn to complete.
+func runContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	if a.lines && outa {
+}
+
+// JJMain runs jj with the process arguments and standard streams.
+func JJMain() (int, error) {
+	return Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr), nil
+}
+
+// Run runs jj with the command line arguments in args, which exclude the
+// program name, reading the input from in when no input file is given and
+// writing the output to out. Usage messages and errors are written to
+// errOut. The returned value is the process exit code.
+func Run(args []string, in io.Reader, out, errOut io.Writer) int {
+	if errorFormat(args) == "json" {
+		errOut = &jsonErrors{errOut}
+	}
+	code, err := run(args, in, out, errOut)
+	if err != nil {
+		reportError(errOut, err, code)
+	}
+	return code
+}
+
+// format renders a result as it's written to the output, applying the
+// formatting and coloring options.
+func format(a args, res result, notes map[string]string, tty bool) []byte {
+	outb := res.data
+	if res.text {
+		if a.join {
+			return bytes.TrimSuffix(outb, []byte("\n"))
+		}
+		if len(outb) > 0 && outb[len(outb)-1] != '\n' {
+			outb = append(outb, '\n')
+		}
+		return outb
+	}
+	if a.omitnull && (a.raw || res.typ != gjson.String) {
+		outb = omitNulls(outb, a.omitnulla)
+	}
+	if notes != nil && (a.raw || res.typ != gjson.String) {
+		// annotated output is JSONC, which the remaining formatting
+		// and coloring steps do not understand
+		outb = annotate(outb, notes)
+	} else if a.lines && res.array {
 		var outb2 []byte
+		sep := byte('\n')
+		if a.nul || a.nulend {
+			sep = 0
+		}
 		gjson.ParseBytes(outb).ForEach(func(_, v gjson.Result) bool {
-			outb2 = append(outb2, pretty.Ugly([]byte(v.Raw))...)
-			outb2 = append(outb2, '\n')
+			if sep == 0 && v.Type == gjson.String {
+				outb2 = append(outb2, v.Str...)
+			} else {
+				outb2 = append(outb2, pretty.Ugly([]byte(v.Raw))...)
+			}
+			outb2 = append(outb2, sep)
 			return true
 		})
 		outb = outb2
-	} else if a.raw || outt != gjson.String {
+	} else if a.raw || res.typ != gjson.String {
 		if a.pretty {
-			outb = pretty.Pretty(outb)
+			outb = pretty.PrettyOptions(outb, a.popts)
 		} else if a.ugly {
+			if a.popts != nil && a.popts.SortKeys {
+				outb = pretty.PrettyOptions(outb, a.popts)
+			}
 			outb = pretty.Ugly(outb)
 		}
 	}
-	if a.raw && (!a.pretty && !a.ugly) {
-		outb = pretty.Pretty(outb)
+	if a.raw && (!a.pretty && !a.ugly) && notes == nil &&
+		!(a.lines && res.array) {
+		outb = pretty.PrettyOptions(outb, a.popts)
+	}
+	if a.unquote && (a.raw || res.typ != gjson.String) {
+		outb = unquoteKeys(outb)
 	}
-	if !a.notty && isatty.IsTerminal(f.Fd()) {
-		if a.raw || outt != gjson.String {
-			outb = pretty.Color(outb, pretty.TerminalStyle)
+	if a.nul || a.nulend {
+		// NUL separated output is never colored, and it ends with a NUL
+		// rather than a newline
+		if !a.lines || !res.array {
+			if a.raw || res.typ != gjson.String {
+				outb = bytes.TrimRight(outb, "\n")
+			}
+			outb = append(outb, 0)
+		}
+		return outb
+	}
+	if !a.notty && notes == nil && !a.unquote && !a.typeof && tty {
+		if a.raw || res.typ != gjson.String {
+			outb = pretty.Color(outb, a.style)
 		} else {
-			outb = append([]byte(pretty.TerminalStyle.String[0]), outb...)
-			outb = append(outb, pretty.TerminalStyle.String[1]...)
+			outb = append([]byte(a.style.String[0]), outb...)
+			outb = append(outb, a.style.String[1]...)
 		}
 		for len(outb) > 0 && outb[len(outb)-1] == '\n' {
 			outb = outb[:len(outb)-1]
 		}
+		if a.join {
+			return outb
+		}
 		outb = append(outb, '\n')
 	}
+	if a.join {
+		// a string value is written as it is, with its own newlines
+		if a.raw || res.typ != gjson.String {
+			outb = bytes.TrimSuffix(outb, []byte("\n"))
+		}
+		return outb
+	}
 	if len(outb) > 0 && outb[len(outb)-1] != '\n' {
 		outb = append(outb, '\n')
 	}
-	f.Write(outb)
-	f.Close()
+	return outb
+}
+
+// formatOf returns the format of a file from its extension, yaml for .yaml
+// and .yml files, toml for .toml files, jsonc and json5 for .jsonc and .json5
+// files, or an empty string for JSON. The
+// extension of a URL is the one of its path.
+func formatOf(name string) string {
+	if u, err := url.Parse(name); err == nil && isURL(name) {
+		name = u.Path
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	case ".jsonc":
+		return "jsonc"
+	case ".json5":
+		return "json5"
+	}
+	return ""
+}
+
+// useColor reports whether the output written to f is colored, which is when
+// f is a terminal unless --color always is given. Color may still be turned
+// off with -n or --color never.
+func useColor(a args, f *outputFile) bool {
+	return a.color == "always" || f.isTerminal()
+}
+
+// openOutput opens the output file given with -o or -I, or wraps out when
+// there is none. An upload to a URL is canceled when ctx is done.
+func openOutput(ctx context.Context, a args, out io.Writer) (*outputFile, error) {
+	if a.outfile == nil {
+		return &outputFile{Writer: out}, nil
+	}
+	if isURL(*a.outfile) {
+		return createUpload(ctx, a, *a.outfile), nil
+	}
+	f, err := createOutput(*a.outfile)
+	if err != nil {
+		return nil, err
+	}
+	if a.backup {
+		f.backup = *a.outfile + ".bak"
+	}
+	return f, nil
+}
+
+func run(argv []string, in io.Reader, out, errOut io.Writer) (int, error) {
+	a, shouldExit, exitCode := parseArgs(argv, out, errOut)
+	if shouldExit {
+		return exitCode, nil
+	}
+	// the deadline of --timeout is the same for all the runs of -w and
+	// all the files of --each
+	ctx := context.Background()
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+	if a.watch {
+		return watch(ctx, a, in, out, errOut)
+	}
+	if a.each != nil {
+		return each(ctx, a, out, errOut)
+	}
+	return execute(ctx, a, in, out, errOut)
+}
+
+// timedOut returns the error of an operation stopped by --timeout.
+func timedOut(a args) (int, error) {
+	return exitTimeout, fmt.Errorf("operation timed out after %s", a.timeout)
+}
+
+// execute performs the operation described by the parsed arguments, until
+// ctx is done.
+func execute(ctx context.Context, a args, in io.Reader, out, errOut io.Writer) (int, error) {
+	registerModifiers(a.modifiers, errOut)
+	if a.command == "escape" {
+		for i, key := range a.keypaths {
+			a.keypaths[i] = escapeKey(key)
+		}
+		io.WriteString(out, strings.Join(a.keypaths, ".")+"\n")
+		return 0, nil
+	}
+	if a.explainq {
+		if !a.keypathok {
+			fail(errOut, "missing required option: \"keypath\"")
+			return exitUsage, nil
+		}
+		io.WriteString(out, explainQuery(a.keypath))
+		return 0, nil
+	}
+	var input, orig []byte
+	var source []byte // the JSONC input as read, with its comments
+	var err error
+	var res result
+	var notes map[string]string
+	var f *outputFile
+	var unmap func()
+	if a.annotate != nil {
+		var data []byte
+		data, err = os.ReadFile(*a.annotate)
+		if err != nil {
+			goto fail
+		}
+		notes, err = parseAnnotations(data)
+		if err != nil {
+			goto fail
+		}
+	}
+	if a.theme != nil {
+		if a.style, err = parseStyle(*a.theme); err != nil {
+			err = fmt.Errorf("--color-theme: %v", err)
+			goto fail
+		}
+	} else {
+		// JJ_COLORS is another name for JJ_STYLE, which is preferred
+		name := "JJ_STYLE"
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			name = "JJ_COLORS"
+			env = os.Getenv(name)
+		}
+		a.style, err = parseStyle(env)
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: %s: %v, using the default\n",
+				name, err)
+			a.style = pretty.TerminalStyle
+		}
+	}
+	if a.style == nil {
+		a.notty = true
+	}
+	if err = readValues(a.edits, in); err != nil {
+		goto fail
+	}
+	if a.patchfile != nil {
+		if a.patch, err = readFile(*a.patchfile, in); err != nil {
+			goto fail
+		}
+	}
+	if a.mpatchfile != nil {
+		if a.mpatch, err = readFile(*a.mpatchfile, in); err != nil {
+			goto fail
+		}
+	}
+	if a.schemafile != nil {
+		if a.schema, err = readFile(*a.schemafile, in); err != nil {
+			goto fail
+		}
+	}
+	if a.command == "cmp" {
+		var data1, data2 []byte
+		if data1, err = readDocument(a.keypath, in); err != nil {
+			goto fail
+		}
+		if data2, err = readDocument(a.keypaths[0], in); err != nil {
+			goto fail
+		}
+		if f, err = openOutput(ctx, a, out); err != nil {
+			goto fail
+		}
+		defer f.Abort()
+		keypath := ""
+		if len(a.keypaths) > 1 {
+			keypath = a.keypaths[1]
+		}
+		diffs := compareDocuments(data1, data2, keypath,
+			!a.notty && useColor(a, f))
+		if !a.quiet {
+			if _, err = f.Write(diffs); err != nil {
+				goto fail
+			}
+		}
+		if err = f.Commit(); err != nil {
+			goto fail
+		}
+		if len(diffs) > 0 {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if a.command == "diff" {
+		var data1, data2 []byte
+		if data1, err = readFile(a.keypath, in); err != nil {
+			goto fail
+		}
+		if data2, err = readFile(a.keypaths[0], in); err != nil {
+			goto fail
+		}
+		if res.data, err = Diff(data1, data2); err != nil {
+			goto fail
+		}
+		res.typ = gjson.JSON
+		res.array = true
+		if f, err = openOutput(ctx, a, out); err != nil {
+			goto fail
+		}
+		defer f.Abort()
+		if _, err = f.Write(format(a, res, nil, useColor(a, f))); err != nil {
+			goto fail
+		}
+		if err = f.Commit(); err != nil {
+			goto fail
+		}
+		return 0, nil
+	}
+	if a.unquote {
+		fmt.Fprintf(errOut,
+			"warning: --unquote-keys output is not strict JSON\n")
+	}
+	if a.validate {
+		err = runContext(ctx, func() error {
+			return validateInput(ctx, a, in)
+		})
+		if err != nil {
+			goto fail
+		}
+		return 0, nil
+	}
+	if a.ndjson || a.multi {
+		f, err = openOutput(ctx, a, out)
+		if err != nil {
+			goto fail
+		}
+		defer f.Abort()
+		var missing bool
+		err = runContext(ctx, func() error {
+			var err error
+			if a.ndjson {
+				missing, err = streamLines(ctx, a, in, f, errOut, notes)
+			} else {
+				missing, err = streamDocuments(ctx, a, in, f, errOut, notes)
+			}
+			return err
+		})
+		if err != nil {
+			goto fail
+		}
+		if err = f.Commit(); err != nil {
+			goto fail
+		}
+		if missing {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	defer func() {
+		// the mapped input may still be read when the timeout expired
+		if ctx.Err() == nil && unmap != nil {
+			unmap()
+		}
+	}()
+	err = runContext(ctx, func() error {
+		var err error
+		if a.mergedocs {
+			input, err = mergeInputs(ctx, a, in)
+			return err
+		}
+		if a.mmap && len(a.edits) == 0 {
+			// the edits make a copy of the document anyway
+			input, unmap, err = mapFile(a.infiles[0])
+		} else {
+			input, err = readInput(ctx, a, in)
+		}
+		if err == nil && a.envsubst {
+			input = envSubst(input)
+		}
+		strict := a.strict && (a.from == "" || a.from == "json")
+		if err == nil && strict && !a.slurp {
+			err = strictJSON(input)
+		}
+		if err == nil && a.slurp {
+			input, err = slurp(input)
+			if err == nil && strict {
+				err = strictJSON(input)
+			}
+		}
+		if err == nil && a.from == "yaml" {
+			input, err = yamlToJSON(input)
+			err = invalidInput(err)
+		} else if err == nil && a.from == "toml" {
+			input, a.tomldates, err = tomlToJSON(input)
+			err = invalidInput(err)
+		} else if err == nil && (a.from == "jsonc" || a.from == "json5") {
+			if a.from == "jsonc" {
+				source = input
+			}
+			input, err = relaxedToJSON(input)
+			err = invalidInput(err)
+		}
+		return err
+	})
+	if err != nil {
+		goto fail
+	}
+	if a.inplace {
+		// an edit of a file that is not JSON makes no sense of it, and
+		// would replace it with whatever is made of it
+		if err = validJSON(input); err != nil {
+			goto fail
+		}
+	}
+	if a.repl {
+		if err = explore(a, input); err != nil {
+			goto fail
+		}
+		return 0, nil
+	}
+	if a.test {
+		var ok bool
+		if ok, err = testValue(a, input); err != nil {
+			goto fail
+		}
+		if !ok {
+			return 1, nil
+		}
+		if a.quiet {
+			return 0, nil
+		}
+	}
+	if a.command == "validate" {
+		var violations []Violation
+		if violations, err = Validate(a.schema, input); err != nil {
+			goto fail
+		}
+		for _, v := range violations {
+			path := v.Keypath
+			if path == "" {
+				path = "(root)"
+			}
+			fmt.Fprintf(out, "%s: %s\n", path, v.Reason)
+		}
+		if len(violations) > 0 {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if a.eqpaths != nil {
+		v1 := gjson.GetBytes(input, a.eqpaths[0])
+		v2 := gjson.GetBytes(input, a.eqpaths[1])
+		if !v1.Exists() || !v2.Exists() || !jsonEqual(v1, v2) {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if a.diff {
+		// -O may edit the input in place
+		orig = append([]byte(nil), input...)
+	}
+	err = runContext(ctx, func() error {
+		var err error
+		res, err = process(ctx, a, input)
+		return err
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		goto fail
+	}
+	if res.diag != "" {
+		fmt.Fprintf(errOut, "%s\n", res.diag)
+	}
+	if err != nil {
+		switch a.onerror {
+		case "skip":
+			return 0, nil
+		case "empty":
+			res = result{}
+		default:
+			goto fail
+		}
+	}
+	if res.missing && (a.exists || (a.del && a.quiet)) {
+		return 1, nil
+	}
+	if a.inplace && !res.text && (a.raw || res.typ != gjson.String) &&
+		!gjson.ValidBytes(res.data) {
+		err = invalidInput(errors.New("the edited document is not valid JSON, " +
+			"the file is left unchanged"))
+		goto fail
+	}
+	if a.explode && len(res.data) > 0 {
+		if res.data, err = explode(res.data); err != nil {
+			goto fail
+		}
+		res.text = true
+	}
+	if a.export && len(res.data) > 0 {
+		if res.data, err = jsonToExport(res.data); err != nil {
+			goto fail
+		}
+		res.text = true
+	}
+	if a.csv != 0 && len(res.data) > 0 {
+		if res.data, err = jsonToCSV(res.data, a.csv); err != nil {
+			goto fail
+		}
+		res.text = true
+	}
+	if res, err = applyTemplate(a, res); err != nil {
+		goto fail
+	}
+	if source != nil && (a.to == "" || a.to == "jsonc") &&
+		len(a.edits) > 0 && !a.pretty && !a.ugly && !a.diff &&
+		len(res.data) > 0 {
+		// only the edited part of the document is written, with the
+		// comments around it as they are
+		if data, ok := keepComments(source, res.data); ok {
+			res.data = data
+			res.text = true
+		} else {
+			fmt.Fprintf(errOut, "warning: the comments of the input "+
+				"could not be kept\n")
+		}
+	}
+	if (a.from == "jsonc" || a.from == "json5") && !res.text &&
+		(a.raw || res.typ != gjson.String) {
+		// the spaces left by the comments
+		res.data = trimLineEnds(res.data)
+	}
+	if (a.to == "yaml" || a.to == "toml") &&
+		(a.raw || res.typ != gjson.String) && len(res.data) > 0 {
+		if a.to == "yaml" {
+			res.data, err = jsonToYAML(res.data)
+		} else {
+			dates := a.tomldates
+			if a.keypathok && len(a.edits) == 0 {
+				// the keypaths of the dates are those of the document
+				dates = nil
+			}
+			res.data, err = jsonToTOML(res.data, dates)
+		}
+		if err != nil {
+			goto fail
+		}
+		res.text = true
+	}
+	if a.diff {
+		before := pretty.Pretty(orig)
+		after := pretty.Pretty(res.data)
+		if bytes.Equal(before, after) {
+			return 0, nil
+		}
+		name := "stdin"
+		if len(a.infiles) > 0 {
+			name = a.infiles[0]
+		}
+		f = &outputFile{Writer: out}
+		_, err = out.Write(unifiedDiff(before, after, name, name,
+			!a.notty && useColor(a, f)))
+		if err != nil {
+			goto fail
+		}
+		return 0, nil
+	}
+	f, err = openOutput(ctx, a, out)
+	if err != nil {
+		goto fail
+	}
+	defer f.Abort()
+	if _, err = f.Write(format(a, res, notes, useColor(a, f))); err != nil {
+		goto fail
+	}
+	if err = f.Commit(); err != nil {
+		goto fail
+	}
+	if (a.del || a.findvalue != nil) && res.missing {
+		return 1, nil
+	}
 	return 0, nil
 fail:
-	return 1, err
+	if errors.Is(err, context.DeadlineExceeded) {
+		return timedOut(a)
+	}
+	return exitCode(err), err
 }
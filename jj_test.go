@@ -0,0 +1,359 @@
+package jj
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runJJ runs jj with args on the input, failing the test unless it exits
+// with 0, and returns the output.
+func runJJ(t *testing.T, input string, args ...string) string {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	if code := Run(args, strings.NewReader(input), &out, &errOut); code != 0 {
+		t.Fatalf("jj %s: exit status %d: %s", strings.Join(args, " "), code,
+			errOut.String())
+	}
+	return out.String()
+}
+
+func TestPreserveNumberFormat(t *testing.T) {
+	const doc = `{"big":12345678901234567890123,` +
+		`"pi":3.14159265358979323846264338327950288,"price":1.00,` +
+		`"exp":1E+2,"list":[1.50,-0.0,9007199254740993]}`
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"big"}, "12345678901234567890123\n"},
+		{[]string{"pi"}, "3.14159265358979323846264338327950288\n"},
+		{[]string{"price"}, "1.00\n"},
+		{[]string{"exp"}, "1E+2\n"},
+		{[]string{"list.2"}, "9007199254740993\n"},
+		{[]string{"list"}, "[1.50,-0.0,9007199254740993]\n"},
+		{[]string{"big", "price"}, "[12345678901234567890123,1.00]\n"},
+		{[]string{"@this"}, doc + "\n"},
+		{[]string{"-u", "@this"}, doc + "\n"},
+		{[]string{"-v", "x", "name"}, doc[:len(doc)-1] + `,"name":"x"}` + "\n"},
+		{[]string{"-v", "2.50", "price"}, strings.Replace(doc, "1.00", "2.50", 1) + "\n"},
+		{[]string{"-D", "exp"}, strings.Replace(doc, `"exp":1E+2,`, "", 1) + "\n"},
+	}
+	for _, tt := range tests {
+		args := append([]string{"--preserve-number-format"}, tt.args...)
+		if got := runJJ(t, doc, args...); got != tt.want {
+			t.Errorf("jj %s\n got %q\nwant %q", strings.Join(args, " "), got,
+				tt.want)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		input string
+		args  []string
+		want  string
+	}{
+		// a missing path
+		{`{}`, []string{"-a", "42", "nums"}, `{"nums":[42]}`},
+		{`{"a":{}}`, []string{"-a", "x", "a.b.c"}, `{"a":{"b":{"c":["x"]}}}`},
+		// an empty array
+		{`{"nums":[]}`, []string{"-a", "42", "nums"}, `{"nums":[42]}`},
+		{`{"nums":[]}`, []string{"-v", "42", "nums.-1"}, `{"nums":[42]}`},
+		// a nested array
+		{`{"a":{"b":[1]}}`, []string{"-a", "x", "a.b"}, `{"a":{"b":[1,"x"]}}`},
+		{`{"a":[{"c":[]}]}`, []string{"-a", "true", "a.0.c"},
+			`{"a":[{"c":[true]}]}`},
+		{`{"f":["apple"]}`, []string{"-v", "orange", "f.-1"},
+			`{"f":["apple","orange"]}`},
+		// the value types are detected like with -v, unless -s or -r
+		{`{"n":[1]}`, []string{"-a", "2", "n", "-a", "null", "n", "-s", "-a", "3",
+			"n"}, `{"n":[1,2,null,"3"]}`},
+		{`{"n":[1]}`, []string{"-r", "-u", "-a", `{"x":1}`, "n"}, `{"n":[1,{"x":1}]}`},
+		{`{"n":[1]}`, []string{"-O", "-a", "2", "n"}, `{"n":[1,2]}`},
+	}
+	for _, tt := range tests {
+		if got := runJJ(t, tt.input, tt.args...); got != tt.want+"\n" {
+			t.Errorf("jj %s on %s\n got %q\nwant %q",
+				strings.Join(tt.args, " "), tt.input, got, tt.want+"\n")
+		}
+	}
+}
+
+func TestAppendNotArray(t *testing.T) {
+	tests := []struct {
+		input string
+		args  []string
+	}{
+		{`{"a":{"x":1}}`, []string{"-a", "42", "a"}},
+		{`{"a":"s"}`, []string{"-a", "42", "a"}},
+		{`{"a":5}`, []string{"-a", "42", "a"}},
+		{`{"a":null}`, []string{"-a", "42", "a"}},
+		{`{"a":{"x":1}}`, []string{"-v", "42", "a.-1"}},
+		{`{"a":true}`, []string{"-O", "-a", "42", "a"}},
+	}
+	for _, tt := range tests {
+		var out, errOut bytes.Buffer
+		code := Run(tt.args, strings.NewReader(tt.input), &out, &errOut)
+		if code != exitError || out.Len() != 0 ||
+			!strings.Contains(errOut.String(), "not an array") {
+			t.Errorf("jj %s on %s: exit status %d, output %q, error %q",
+				strings.Join(tt.args, " "), tt.input, code, out.String(),
+				errOut.String())
+		}
+	}
+}
+
+func TestAutoRaw(t *testing.T) {
+	tests := []struct {
+		value string
+		raw   bool
+	}{
+		{"007", false},
+		{"9007199254740993", true},
+		{"123456789012345678901234567890", true},
+		{"1e309", false},
+		{"-1e309", false},
+		{"1e308", true},
+		{"-5551234", true},
+		{"-555-1234", false},
+		{"1_000", false},
+		{"0", true},
+		{"-0", true},
+		{"0.5", true},
+		{".5", false},
+		{"1.", false},
+		{"+1", false},
+		{"0x10", false},
+		{"true", true},
+		{"null", true},
+		{"True", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := autoRaw(tt.value); got != tt.raw {
+			t.Errorf("autoRaw(%q) = %v, want %v", tt.value, got, tt.raw)
+		}
+	}
+}
+
+func TestSetNumericLooking(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"007", `{"v":"007"}`},
+		{"9007199254740993", `{"v":9007199254740993}`},
+		{"1e309", `{"v":"1e309"}`},
+		{"-5551234", `{"v":-5551234}`},
+		{"1_000", `{"v":"1_000"}`},
+	}
+	for _, tt := range tests {
+		if got := runJJ(t, `{}`, "-v", tt.value, "v"); got != tt.want+"\n" {
+			t.Errorf("jj -v %s v: got %q, want %q", tt.value, got, tt.want+"\n")
+		}
+	}
+}
+
+func TestFindValueExitStatus(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"--find-value", "1"}, 0},
+		{[]string{"--find-value", "2"}, 1},
+		{[]string{"--find-value", "2", "--all"}, 1},
+	}
+	for _, tt := range tests {
+		var out, errOut bytes.Buffer
+		code := Run(tt.args, strings.NewReader(`{"a":1}`), &out, &errOut)
+		if code != tt.code {
+			t.Errorf("jj %s: exit status %d, want %d", strings.Join(tt.args, " "),
+				code, tt.code)
+		}
+	}
+}
+
+func TestSeveralInputs(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+	if err := os.WriteFile(a, []byte(`{"a":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte(`{"a":2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"a"}, "1\n2\n"},
+		{[]string{"-u", "@this"}, `{"a":1}` + "\n" + `{"a":2}` + "\n"},
+		{[]string{"-v", "5", "b"}, `{"a":1,"b":5}` + "\n" + `{"a":2,"b":5}` + "\n"},
+		{[]string{"--slurp", "#.a"}, "[1,2]\n"},
+	}
+	for _, tt := range tests {
+		args := append([]string{"-i", a, "-i", b}, tt.args...)
+		if got := runJJ(t, "", args...); got != tt.want {
+			t.Errorf("jj %s: got %q, want %q", strings.Join(tt.args, " "), got,
+				tt.want)
+		}
+	}
+}
+
+func TestInPlaceInvalidInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(path, []byte(`{bad`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out, errOut bytes.Buffer
+	code := Run([]string{"-I", "-i", path, "-v", "1", "a"},
+		strings.NewReader(""), &out, &errOut)
+	if code != exitSyntax {
+		t.Errorf("exit status %d, want %d", code, exitSyntax)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{bad` {
+		t.Errorf("file is %q, want it unchanged", data)
+	}
+}
+
+func TestOutputArray(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--output", "array", "a"}, "[1]\n"},
+		{[]string{"--output", "array", "a", "b"}, "[1,2]\n"},
+		{[]string{"--output", "array", "c"}, "[null]\n"},
+		{[]string{"a"}, "1\n"},
+	}
+	for _, tt := range tests {
+		if got := runJJ(t, `{"a":1,"b":2}`, tt.args...); got != tt.want {
+			t.Errorf("jj %s: got %q, want %q", strings.Join(tt.args, " "), got,
+				tt.want)
+		}
+	}
+}
+
+func TestMoveIntoItself(t *testing.T) {
+	for _, move := range [][]string{{"a", "a"}, {"a", "a.b"}, {"a.0", "a.0.x"}} {
+		args := append([]string{"--move"}, move...)
+		var out, errOut bytes.Buffer
+		code := Run(args, strings.NewReader(`{"a":[{"b":1}]}`), &out, &errOut)
+		if code != exitError || out.Len() != 0 {
+			t.Errorf("jj %s: exit status %d, output %q", strings.Join(args, " "),
+				code, out.String())
+		}
+	}
+	if got := runJJ(t, `{"a":[1,2],"ab":3}`, "--move", "a.0", "a.1"); got !=
+		`{"a":[2,1],"ab":3}`+"\n" {
+		t.Errorf("jj --move a.0 a.1: got %q", got)
+	}
+}
+
+func TestStrictLinesError(t *testing.T) {
+	for _, args := range [][]string{{"-J", "--strict", "a"},
+		{"-J", "--strict", "--validate"}} {
+		var out, errOut bytes.Buffer
+		code := Run(args, strings.NewReader("{\"a\":1}\n{bad\n"), &out, &errOut)
+		msg, _, _ := strings.Cut(errOut.String(), "\n")
+		if code != exitSyntax || !strings.Contains(msg, ": line 2, column 2: ") ||
+			strings.Contains(msg, "line 1") {
+			t.Errorf("jj %s: exit status %d, error %q", strings.Join(args, " "),
+				code, msg)
+		}
+	}
+}
+
+func TestConcatFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.json": `{"a":1}`, "b.yaml": "a: 2\n",
+		"c.toml": "a = 3\n", "bad.yaml": "a: [1\n"}
+	for name, data := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	args := []string{"--concat", "-i", filepath.Join(dir, "b.yaml"), "-i",
+		filepath.Join(dir, "a.json"), "-i", filepath.Join(dir, "c.toml"), "a"}
+	if got := runJJ(t, "", args...); got != "2\n1\n3\n" {
+		t.Errorf("jj %s: got %q, want %q", strings.Join(args, " "), got,
+			"2\n1\n3\n")
+	}
+	bad := filepath.Join(dir, "bad.yaml")
+	var out, errOut bytes.Buffer
+	code := Run([]string{"--concat", "-i", filepath.Join(dir, "a.json"), "-i",
+		bad, "a"}, strings.NewReader(""), &out, &errOut)
+	if code != exitSyntax || !strings.Contains(errOut.String(),
+		bad+" (read as yaml): ") {
+		t.Errorf("exit status %d, error %q", code, errOut.String())
+	}
+}
+
+func TestModifierCommandFailure(t *testing.T) {
+	args := []string{"--modifier", "fail=exit 3", "a|@fail"}
+	var out, errOut bytes.Buffer
+	code := Run(args, strings.NewReader(`{"a":"x"}`), &out, &errOut)
+	if code != exitError || out.Len() != 0 ||
+		!strings.Contains(errOut.String(), `modifier command "exit 3"`) {
+		t.Errorf("exit status %d, output %q, error %q", code, out.String(),
+			errOut.String())
+	}
+	// the failure is not kept for the next run
+	if got := runJJ(t, `{"a":"x"}`, "a"); got != "x\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	const doc = `{"a":1,"b":"x y","db":{"host":"localhost"}}`
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--export"},
+			"export a='1'\nexport b='x y'\nexport db='{\"host\":\"localhost\"}'\n"},
+		{[]string{"--export", "db"}, "export host='localhost'\n"},
+	}
+	for _, tt := range tests {
+		if got := runJJ(t, doc, tt.args...); got != tt.want {
+			t.Errorf("jj %s: got %q, want %q", strings.Join(tt.args, " "), got,
+				tt.want)
+		}
+	}
+}
+
+func TestCastNumber(t *testing.T) {
+	tests := []struct {
+		value, want string
+		err         bool
+	}{
+		{"1.0", "1.0", false},
+		{"+1", "1", false},
+		{"12345678901234567890123", "12345678901234567890123", false},
+		{"1e308", "1e308", false},
+		{"1e999", "", true},
+		{"-1e999", "", true},
+		{"NaN", "", true},
+		{"inf", "", true},
+		{"x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := castValue(edit{value: tt.value, force: 'n'})
+		if tt.err {
+			if err == nil {
+				t.Errorf("castValue(%q) = %s, want an error", tt.value, got)
+			}
+		} else if err != nil {
+			t.Errorf("castValue(%q): %v", tt.value, err)
+		} else if string(got) != tt.want {
+			t.Errorf("castValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package jj
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+)
+
+// keepComments returns the edited document with the comments and trailing
+// commas of the JSONC source that it was made from, and reports whether it
+// could. The documents are compared value by value to find the one that the
+// edit changed, and only that part of the source is rewritten: a changed
+// value is replaced, a removed member is taken out along with its comma and
+// the rest of its line, and a new member is written like the ones around it,
+// on its own line with their indentation when they have their own lines.
+// Anything else changed at once replaces the containing value, losing the
+// comments inside it.
+func keepComments(source, edited []byte) ([]byte, bool) {
+	blank, err := relaxedToJSON(source)
+	if err != nil || len(blank) != len(source) {
+		// the values are not at the same offsets as in the source
+		return nil, false
+	}
+	root := gjson.ParseBytes(blank)
+	root.Index = len(blank) - len(bytes.TrimLeft(blank, " \t\r\n"))
+	c := commentedDoc{source: source, blank: blank}
+	out := c.splice(root, gjson.ParseBytes(edited))
+	// the result must read as the edited document
+	check, err := relaxedToJSON(out)
+	if err != nil || !bytes.Equal(pretty.Ugly(check), pretty.Ugly(edited)) {
+		return nil, false
+	}
+	return out, true
+}
+
+// commentedDoc is a JSONC source along with the JSON of relaxedToJSON, blank,
+// which has its values at the same offsets and spaces for its comments.
+type commentedDoc struct {
+	source, blank []byte
+}
+
+// member is a member of an object, or an element of an array with no key.
+type member struct {
+	key, value gjson.Result
+}
+
+// start returns the offset of the member.
+func (m member) start() int {
+	if m.key.Exists() {
+		return m.key.Index
+	}
+	return m.value.Index
+}
+
+// end returns the offset just past the member's value.
+func (m member) end() int {
+	return m.value.Index + len(m.value.Raw)
+}
+
+func members(v gjson.Result) []member {
+	var ms []member
+	v.ForEach(func(key, value gjson.Result) bool {
+		if !v.IsObject() {
+			key = gjson.Result{}
+		}
+		ms = append(ms, member{key, value})
+		return true
+	})
+	return ms
+}
+
+// splice returns the source with the value old of blank written as the
+// edited value v.
+func (c commentedDoc) splice(old, v gjson.Result) []byte {
+	if old.Raw == v.Raw {
+		return c.source
+	}
+	if !(old.IsObject() && v.IsObject()) && !(old.IsArray() && v.IsArray()) {
+		return c.replace(old.Index, old.Index+len(old.Raw), v.Raw)
+	}
+	olds, news := members(old), members(v)
+	same := func(i, j int) bool {
+		return olds[i].key.Raw == news[j].key.Raw &&
+			olds[i].value.Raw == news[j].value.Raw
+	}
+	p := 0
+	for p < len(olds) && p < len(news) && same(p, p) {
+		p++
+	}
+	q := 0
+	for q < len(olds)-p && q < len(news)-p &&
+		same(len(olds)-1-q, len(news)-1-q) {
+		q++
+	}
+	switch {
+	case len(olds)-p-q == 1 && len(news)-p-q == 1 &&
+		olds[p].key.Raw == news[p].key.Raw:
+		return c.splice(olds[p].value, news[p].value)
+	case len(olds)-p-q == 1 && len(news)-p-q == 0:
+		return c.remove(olds, p)
+	case len(olds)-p-q == 0 && len(news)-p-q == 1 && len(olds) > 0:
+		return c.add(old, olds, p, news[p])
+	}
+	return c.replace(old.Index, old.Index+len(old.Raw), v.Raw)
+}
+
+// replace returns the source with the bytes from start to end replaced with
+// the text.
+func (c commentedDoc) replace(start, end int, text string) []byte {
+	out := append([]byte(nil), c.source[:start]...)
+	out = append(out, text...)
+	return append(out, c.source[end:]...)
+}
+
+// lineStart returns the offset of the start of the line of the member, and
+// whether the member is the first thing on the line.
+func (c commentedDoc) lineStart(m member) (int, bool) {
+	i := m.start()
+	for i > 0 && (c.source[i-1] == ' ' || c.source[i-1] == '\t') {
+		i--
+	}
+	return i, i == 0 || c.source[i-1] == '\n'
+}
+
+// comma returns the offset just past the comma after the member, or -1 when
+// there's none, like after the last member without a trailing comma.
+func (c commentedDoc) comma(m member) int {
+	i := m.end()
+	for i < len(c.blank) && c.source[i] != ',' &&
+		strings.IndexByte(" \t\r\n", c.blank[i]) >= 0 {
+		i++
+	}
+	if i < len(c.source) && c.source[i] == ',' {
+		return i + 1
+	}
+	return -1
+}
+
+// lineEnd returns the offset just past the end of the line at i, and
+// whether only spaces and comments are left on it.
+func (c commentedDoc) lineEnd(i int) (int, bool) {
+	for i < len(c.blank) && strings.IndexByte(" \t\r", c.blank[i]) >= 0 {
+		i++
+	}
+	if i < len(c.blank) && c.blank[i] == '\n' {
+		return i + 1, true
+	}
+	return i, false
+}
+
+// newline returns the line ending of the line that ends just before i.
+func (c commentedDoc) newline(i int) string {
+	if i >= 2 && c.source[i-2] == '\r' {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// remove returns the source without the member ms[i], keeping the comments
+// of the other members.
+func (c commentedDoc) remove(ms []member, i int) []byte {
+	m := ms[i]
+	start, own := c.lineStart(m)
+	if end := c.comma(m); end >= 0 {
+		if lend, rest := c.lineEnd(end); own && rest {
+			return c.replace(start, lend, "")
+		}
+		for end < len(c.source) && (c.source[end] == ' ' ||
+			c.source[end] == '\t') {
+			end++
+		}
+		return c.replace(m.start(), end, "")
+	}
+	// the last member, and the comma before it is no longer needed
+	prev := -1
+	if i > 0 {
+		prev = bytes.LastIndexByte(c.blank[:m.start()], ',')
+	}
+	if lend, rest := c.lineEnd(m.end()); own && rest {
+		if prev < 0 {
+			return c.replace(start, lend, "")
+		}
+		out := append([]byte(nil), c.source[:prev]...)
+		out = append(out, c.source[prev+1:start]...)
+		return append(out, c.source[lend:]...)
+	}
+	if prev < 0 {
+		return c.replace(m.start(), m.end(), "")
+	}
+	return c.replace(prev, m.end(), "")
+}
+
+// add returns the source with the member m added to the object or array v
+// with the members ms, before ms[i] or after the last one.
+func (c commentedDoc) add(v gjson.Result, ms []member, i int, m member) []byte {
+	text := m.value.Raw
+	if m.key.Exists() {
+		// written like the first member, such as "key": value
+		first := ms[0]
+		colon := c.blank[first.key.Index+len(first.key.Raw) : first.value.Index]
+		text = m.key.Raw + string(colon) + text
+	}
+	// the separator of the members on a line, such as ", "
+	sep := ", "
+	if len(ms) > 1 {
+		if end := c.comma(ms[0]); end >= 0 && end == ms[1].start() {
+			sep = ","
+		}
+	}
+	if i < len(ms) {
+		next := ms[i]
+		if start, own := c.lineStart(next); own {
+			indent := string(c.source[start:next.start()])
+			return c.replace(start, start, indent+text+","+c.newline(start))
+		}
+		return c.replace(next.start(), next.start(), text+sep)
+	}
+	last := ms[len(ms)-1]
+	end := c.comma(last)
+	start, own := c.lineStart(last)
+	after := end
+	if after < 0 {
+		after = last.end()
+	}
+	if lend, rest := c.lineEnd(after); own && rest &&
+		lend < v.Index+len(v.Raw) {
+		// on a line of its own before the closing bracket
+		indent := string(c.source[start:last.start()])
+		if end >= 0 {
+			return c.replace(lend, lend, indent+text+","+c.newline(lend))
+		}
+		out := append([]byte(nil), c.source[:last.end()]...)
+		out = append(out, ',')
+		out = append(out, c.source[last.end():lend]...)
+		out = append(out, indent+text+c.newline(lend)...)
+		return append(out, c.source[lend:]...)
+	}
+	return c.replace(last.end(), last.end(), sep+text)
+}
+
+// trimLineEnds removes the whitespace at the end of the lines of a JSON
+// document, like the spaces left by the comments of relaxedToJSON. Strings
+// can't span lines, so it's never part of a string.
+func trimLineEnds(json []byte) []byte {
+	lines := bytes.Split(json, []byte{'\n'})
+	for i, line := range lines {
+		lines[i] = bytes.TrimRight(line, " \t\r")
+	}
+	return bytes.Join(lines, []byte{'\n'})
+}
+
+// relaxedToJSON converts a JSONC or JSON5 document to JSON. The comments and
+// trailing commas are replaced with spaces, keys without quotes and strings
+// with single quotes are written with double quotes, and numbers in
+// hexadecimal or with a leading + or a leading or trailing decimal point are
+// written as JSON numbers. The rest is kept as it is, so a document that
+// only has comments and trailing commas keeps every value at the same
+// offset, and the newlines of block comments keep the lines the same too.
+// Infinity and NaN can't be written in JSON and are an error.
+func relaxedToJSON(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	comma := -1 // the last comma in out, while only whitespace follows
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				out = append(out, ' ')
+				i++
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment at byte offset %d", i)
+			}
+			for _, c := range data[i : i+end+4] {
+				if c != '\n' && c != '\r' {
+					c = ' '
+				}
+				out = append(out, c)
+			}
+			i += end + 4
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			out = append(out, c)
+			i++
+		case c == ',':
+			comma = len(out)
+			out = append(out, c)
+			i++
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+			out = append(out, c)
+			i++
+		case c == '{' || c == '[' || c == ':':
+			comma = -1
+			out = append(out, c)
+			i++
+		case c == '"' || c == '\'':
+			s, n, err := scanJSON5String(data[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at byte offset %d", err, i)
+			}
+			comma = -1
+			if raw := data[i : i+n]; c == '"' && gjson.ValidBytes(raw) {
+				out = append(out, raw...)
+			} else {
+				out = appendJSONString(out, s)
+			}
+			i += n
+		case isJSON5IdentByte(c, true):
+			j := i + 1
+			for j < len(data) && isJSON5IdentByte(data[j], false) {
+				j++
+			}
+			word := string(data[i:j])
+			k := j
+			for k < len(data) && (data[k] == ' ' || data[k] == '\t' ||
+				data[k] == '\n' || data[k] == '\r') {
+				k++
+			}
+			switch {
+			case k < len(data) && data[k] == ':':
+				out = appendJSONString(out, word)
+			case word == "true" || word == "false" || word == "null":
+				out = append(out, word...)
+			case word == "Infinity" || word == "NaN":
+				return nil, fmt.Errorf("%s at byte offset %d can't be "+
+					"written in JSON", word, i)
+			default:
+				return nil, fmt.Errorf("unexpected %q at byte offset %d", word, i)
+			}
+			comma = -1
+			i = j
+		case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(data) && (isJSON5IdentByte(data[j], false) ||
+				data[j] == '.' || ((data[j] == '+' || data[j] == '-') &&
+				(data[j-1] == 'e' || data[j-1] == 'E'))) {
+				j++
+			}
+			num, err := json5Number(string(data[i:j]))
+			if err != nil {
+				return nil, fmt.Errorf("%v at byte offset %d", err, i)
+			}
+			comma = -1
+			out = append(out, num...)
+			i = j
+		default:
+			r, _ := utf8.DecodeRune(data[i:])
+			return nil, fmt.Errorf("invalid character %q at byte offset %d", r, i)
+		}
+	}
+	// what's left, like a missing comma, is a JSON syntax error
+	if err := validJSON(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// isJSON5IdentByte reports whether c can be in a JSON5 key without quotes,
+// or start one. Any byte of a multi-byte UTF-8 character can, which lets
+// keys in other scripts through.
+func isJSON5IdentByte(c byte, start bool) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') || c >= 0x80 || (!start && c >= '0' && c <= '9')
+}
+
+// json5Number returns the JSON5 number s as a JSON number, s itself when
+// it's one already.
+func json5Number(s string) (string, error) {
+	if _, ok := scanNumber(s); ok {
+		return s, nil
+	}
+	num := s
+	neg := false
+	switch {
+	case strings.HasPrefix(num, "+"):
+		num = num[1:]
+	case strings.HasPrefix(num, "-"):
+		num, neg = num[1:], true
+	}
+	if num == "Infinity" || num == "NaN" {
+		return "", fmt.Errorf("%s can't be written in JSON", s)
+	}
+	if strings.HasPrefix(num, "0x") || strings.HasPrefix(num, "0X") {
+		n, err := strconv.ParseUint(num[2:], 16, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number %q", s)
+		}
+		num = strconv.FormatUint(n, 10)
+	} else {
+		if strings.HasPrefix(num, ".") {
+			num = "0" + num
+		}
+		if i := strings.IndexByte(num, '.'); i >= 0 &&
+			(i+1 == len(num) || num[i+1] < '0' || num[i+1] > '9') {
+			num = num[:i+1] + "0" + num[i+1:]
+		}
+	}
+	if neg {
+		num = "-" + num
+	}
+	if _, ok := scanNumber(num); !ok {
+		return "", fmt.Errorf("invalid number %q", s)
+	}
+	return num, nil
+}
+
+// scanJSON5String returns the value of the JSON5 string at the start of
+// data, in single or double quotes, and its length in data.
+func scanJSON5String(data []byte) (string, int, error) {
+	quote := data[0]
+	var sb strings.Builder
+	for i := 1; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == quote:
+			return sb.String(), i + 1, nil
+		case c == '\n' || c == '\r':
+			return "", 0, errors.New("newline in string")
+		case c != '\\':
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(data) {
+			break
+		}
+		switch c := data[i]; c {
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '0':
+			sb.WriteByte(0)
+		case '\r':
+			// a line continuation
+			if i+1 < len(data) && data[i+1] == '\n' {
+				i++
+			}
+		case '\n':
+		case 'x', 'u':
+			n := 2
+			if c == 'u' {
+				n = 4
+			}
+			if i+n >= len(data) {
+				return "", 0, errors.New("invalid escape in string")
+			}
+			v, err := strconv.ParseUint(string(data[i+1:i+1+n]), 16, 32)
+			if err != nil {
+				return "", 0, errors.New("invalid escape in string")
+			}
+			i += n
+			r := rune(v)
+			if utf16.IsSurrogate(r) && i+6 < len(data) && data[i+1] == '\\' &&
+				data[i+2] == 'u' {
+				if v2, err := strconv.ParseUint(string(data[i+3:i+7]), 16, 32); err == nil {
+					if r2 := utf16.DecodeRune(r, rune(v2)); r2 != utf8.RuneError {
+						r = r2
+						i += 6
+					}
+				}
+			}
+			sb.WriteRune(r)
+		default:
+			// any other character stands for itself, like \' or \"
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
@@ -0,0 +1,70 @@
+package jj
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeepComments(t *testing.T) {
+	const doc = "{\n" +
+		"  // the editor\n" +
+		"  \"editor.tabSize\": 2, // spaces\n" +
+		"  \"editor.fontSize\": 14, /* size */\n" +
+		"  \"files.eol\": \"\\n\", // line ends\n" +
+		"}\n"
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-v", "3", `editor\.tabSize`},
+			strings.Replace(doc, `"editor.tabSize": 2`, `"editor.tabSize": 3`, 1)},
+		{[]string{"-D", `editor\.tabSize`},
+			strings.Replace(doc, "  \"editor.tabSize\": 2, // spaces\n", "", 1)},
+		{[]string{"-D", `editor\.fontSize`},
+			strings.Replace(doc, "  \"editor.fontSize\": 14, /* size */\n", "", 1)},
+		{[]string{"-D", `files\.eol`},
+			strings.Replace(doc, "  \"files.eol\": \"\\n\", // line ends\n", "", 1)},
+		{[]string{"-v", "1", "new"},
+			strings.Replace(doc, "}", "  \"new\": 1,\n}", 1)},
+	}
+	for _, tt := range tests {
+		args := append([]string{"--from", "jsonc"}, tt.args...)
+		if got := runJJ(t, doc, args...); got != tt.want {
+			t.Errorf("jj %s\n got %q\nwant %q", strings.Join(tt.args, " "), got,
+				tt.want)
+		}
+	}
+}
+
+func TestKeepCommentsLayout(t *testing.T) {
+	tests := []struct {
+		source, edited, want string
+	}{
+		// the last member without a trailing comma
+		{"{\n  \"a\": 1, // one\n  \"b\": 2 // two\n}",
+			"{\n  \"a\": 1,        \n  \"b\": 2,\"c\":3        \n}",
+			"{\n  \"a\": 1, // one\n  \"b\": 2, // two\n  \"c\": 3\n}"},
+		{"{\n  \"a\": 1, // one\n  \"b\": 2 // two\n}",
+			"{\n  \"a\": 1        \n}",
+			"{\n  \"a\": 1 // one\n}"},
+		// members on a single line
+		{`{"a": 1, /* c */ "b": 2}`, `{"a": 1,           "b": 2,"c":3}`,
+			`{"a": 1, /* c */ "b": 2, "c": 3}`},
+		{`{"a": 1, /* c */ "b": 2}`, `{          "b": 2}`,
+			`{/* c */ "b": 2}`},
+		// array elements
+		{"[\n  1, // one\n  2 // two\n]", "[\n  1,        \n  2,3        \n]",
+			"[\n  1, // one\n  2, // two\n  3\n]"},
+		{"[1, 2] // list", "[0,1, 2]       ", "[0, 1, 2] // list"},
+		// Windows line ends
+		{"{\r\n  \"a\": 1 // one\r\n}\r\n", "{\r\n  \"a\": 1,\"b\":2       \r\n}\r\n",
+			"{\r\n  \"a\": 1, // one\r\n  \"b\": 2\r\n}\r\n"},
+	}
+	for _, tt := range tests {
+		got, ok := keepComments([]byte(tt.source), []byte(tt.edited))
+		if !ok || string(got) != tt.want {
+			t.Errorf("keepComments(%q, %q)\n got %q, %v\nwant %q", tt.source,
+				tt.edited, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package jj
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// Violation is a value of a document that does not satisfy its JSON Schema.
+type Violation struct {
+	Keypath string // keypath of the value, empty for the whole document
+	Reason  string
+}
+
+// maxSchemaDepth limits the nesting of $ref, so that a schema that refers to
+// itself without consuming the document does not recurse forever.
+const maxSchemaDepth = 256
+
+// schemaValidator validates a document against a JSON Schema.
+type schemaValidator struct {
+	root    gjson.Result
+	regexps map[string]*regexp.Regexp
+	err     error
+}
+
+// Validate validates the JSON document against the JSON Schema and returns
+// the violations found, which are none when the document is valid. The
+// keywords of draft 2020-12 are supported, except for unevaluatedItems,
+// unevaluatedProperties, and $ref to other documents; format is only an
+// annotation and is not checked. An error is returned when the schema or the
+// document is not valid JSON, or the schema cannot be used.
+func Validate(schema, doc []byte) ([]Violation, error) {
+	if !gjson.ValidBytes(schema) {
+		return nil, errors.New("schema is not valid JSON")
+	}
+	if !gjson.ValidBytes(doc) {
+		return nil, errors.New("document is not valid JSON")
+	}
+	v := &schemaValidator{
+		root:    gjson.ParseBytes(schema),
+		regexps: make(map[string]*regexp.Regexp),
+	}
+	violations := v.validate(v.root, gjson.ParseBytes(doc), "", 0)
+	if v.err != nil {
+		return nil, v.err
+	}
+	return violations, nil
+}
+
+func (v *schemaValidator) regexp(pattern string) *regexp.Regexp {
+	re, ok := v.regexps[pattern]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil && v.err == nil {
+			v.err = fmt.Errorf("schema pattern \"%s\": %v", pattern, err)
+		}
+		v.regexps[pattern] = re
+	}
+	return re
+}
+
+func (v *schemaValidator) matches(pattern, s string) bool {
+	re := v.regexp(pattern)
+	return re != nil && re.MatchString(s)
+}
+
+// valid reports whether the instance satisfies the schema.
+func (v *schemaValidator) valid(s, inst gjson.Result, depth int) bool {
+	return len(v.validate(s, inst, "", depth)) == 0
+}
+
+func (v *schemaValidator) resolve(ref string) (gjson.Result, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return gjson.Result{}, fmt.Errorf("unsupported $ref: \"%s\"", ref)
+	}
+	p, err := parsePointer(ref[1:])
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	s := p.get([]byte(v.root.Raw))
+	if !s.Exists() {
+		return gjson.Result{}, fmt.Errorf("$ref not found: \"%s\"", ref)
+	}
+	return s, nil
+}
+
+func (v *schemaValidator) validate(s, inst gjson.Result, path string,
+	depth int) []Violation {
+	if v.err != nil {
+		return nil
+	}
+	switch s.Type {
+	case gjson.True:
+		return nil
+	case gjson.False:
+		return []Violation{{path, "no value is allowed"}}
+	}
+	if !s.IsObject() {
+		v.err = errors.New("schema is not an object or a boolean")
+		return nil
+	}
+	var out []Violation
+	report := func(format string, args ...interface{}) {
+		out = append(out, Violation{path, fmt.Sprintf(format, args...)})
+	}
+	child := func(k string) string { return joinPath(path, escapeKey(k)) }
+	index := func(i int) string { return joinPath(path, strconv.Itoa(i)) }
+
+	if ref := s.Get(`\$ref`); ref.Exists() {
+		if depth >= maxSchemaDepth {
+			v.err = errors.New("schema $ref nesting is too deep")
+			return nil
+		}
+		rs, err := v.resolve(ref.String())
+		if err != nil {
+			v.err = err
+			return nil
+		}
+		out = append(out, v.validate(rs, inst, path, depth+1)...)
+	}
+
+	// any type
+	if t := s.Get("type"); t.Exists() {
+		types := []gjson.Result{t}
+		if t.IsArray() {
+			types = t.Array()
+		}
+		ok := false
+		for _, t := range types {
+			if hasSchemaType(inst, t.String()) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			report("expected %s, got %s", joinTypes(types), schemaType(inst))
+		}
+	}
+	if c := s.Get("const"); c.Exists() && !jsonEqual(c, inst) {
+		report("must be %s", c.Raw)
+	}
+	if e := s.Get("enum"); e.Exists() {
+		ok := false
+		e.ForEach(func(_, e gjson.Result) bool {
+			ok = jsonEqual(e, inst)
+			return !ok
+		})
+		if !ok {
+			report("must be one of %s", e.Raw)
+		}
+	}
+	if all := s.Get("allOf"); all.IsArray() {
+		for _, sub := range all.Array() {
+			out = append(out, v.validate(sub, inst, path, depth)...)
+		}
+	}
+	if any := s.Get("anyOf"); any.IsArray() {
+		ok := false
+		for _, sub := range any.Array() {
+			if v.valid(sub, inst, depth) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			report("does not match any of the anyOf schemas")
+		}
+	}
+	if one := s.Get("oneOf"); one.IsArray() {
+		n := 0
+		for _, sub := range one.Array() {
+			if v.valid(sub, inst, depth) {
+				n++
+			}
+		}
+		if n != 1 {
+			report("matches %d of the oneOf schemas, instead of one", n)
+		}
+	}
+	if not := s.Get("not"); not.Exists() && v.valid(not, inst, depth) {
+		report("must not match the not schema")
+	}
+	if cond := s.Get("if"); cond.Exists() {
+		if v.valid(cond, inst, depth) {
+			if then := s.Get("then"); then.Exists() {
+				out = append(out, v.validate(then, inst, path, depth)...)
+			}
+		} else if els := s.Get("else"); els.Exists() {
+			out = append(out, v.validate(els, inst, path, depth)...)
+		}
+	}
+
+	switch {
+	case inst.Type == gjson.Number:
+		x := inst.Float()
+		if m := s.Get("multipleOf"); m.Exists() && m.Float() > 0 {
+			if !isMultipleOf(inst, m) {
+				report("must be a multiple of %s", m.Raw)
+			}
+		}
+		if m := s.Get("maximum"); m.Exists() && x > m.Float() {
+			report("must be <= %s", m.Raw)
+		}
+		if m := s.Get("exclusiveMaximum"); m.Exists() && x >= m.Float() {
+			report("must be < %s", m.Raw)
+		}
+		if m := s.Get("minimum"); m.Exists() && x < m.Float() {
+			report("must be >= %s", m.Raw)
+		}
+		if m := s.Get("exclusiveMinimum"); m.Exists() && x <= m.Float() {
+			report("must be > %s", m.Raw)
+		}
+	case inst.Type == gjson.String:
+		n := int64(utf8.RuneCountInString(inst.Str))
+		if m := s.Get("maxLength"); m.Exists() && n > m.Int() {
+			report("must be at most %d characters long", m.Int())
+		}
+		if m := s.Get("minLength"); m.Exists() && n < m.Int() {
+			report("must be at least %d characters long", m.Int())
+		}
+		if p := s.Get("pattern"); p.Exists() && !v.matches(p.String(), inst.Str) {
+			report("does not match the pattern \"%s\"", p.String())
+		}
+	case inst.IsArray():
+		elems := inst.Array()
+		n := int64(len(elems))
+		prefix := s.Get("prefixItems")
+		items := s.Get("items")
+		if items.IsArray() {
+			// the array form of items of the older drafts
+			prefix, items = items, s.Get("additionalItems")
+		}
+		rest := 0
+		if prefix.IsArray() {
+			for i, sub := range prefix.Array() {
+				if i >= len(elems) {
+					break
+				}
+				out = append(out, v.validate(sub, elems[i], index(i), depth)...)
+				rest = i + 1
+			}
+		}
+		if items.Exists() {
+			for i := rest; i < len(elems); i++ {
+				out = append(out, v.validate(items, elems[i], index(i), depth)...)
+			}
+		}
+		if m := s.Get("maxItems"); m.Exists() && n > m.Int() {
+			report("must have at most %d items", m.Int())
+		}
+		if m := s.Get("minItems"); m.Exists() && n < m.Int() {
+			report("must have at least %d items", m.Int())
+		}
+		if s.Get("uniqueItems").Bool() {
+		unique:
+			for i := range elems {
+				for j := 0; j < i; j++ {
+					if jsonEqual(elems[i], elems[j]) {
+						report("items %d and %d are equal", j, i)
+						break unique
+					}
+				}
+			}
+		}
+		if c := s.Get("contains"); c.Exists() {
+			var count int64
+			for _, e := range elems {
+				if v.valid(c, e, depth) {
+					count++
+				}
+			}
+			min := int64(1)
+			if m := s.Get("minContains"); m.Exists() {
+				min = m.Int()
+			}
+			if count < min {
+				report("must contain at least %d matching items", min)
+			}
+			if m := s.Get("maxContains"); m.Exists() && count > m.Int() {
+				report("must contain at most %d matching items", m.Int())
+			}
+		}
+	case inst.IsObject():
+		members := inst.Map()
+		n := int64(len(members))
+		if m := s.Get("maxProperties"); m.Exists() && n > m.Int() {
+			report("must have at most %d properties", m.Int())
+		}
+		if m := s.Get("minProperties"); m.Exists() && n < m.Int() {
+			report("must have at least %d properties", m.Int())
+		}
+		if req := s.Get("required"); req.IsArray() {
+			for _, k := range req.Array() {
+				if _, ok := members[k.String()]; !ok {
+					report("missing required property \"%s\"", k.String())
+				}
+			}
+		}
+		if dep := s.Get("dependentRequired"); dep.IsObject() {
+			dep.ForEach(func(k, req gjson.Result) bool {
+				if _, ok := members[k.Str]; !ok {
+					return true
+				}
+				for _, r := range req.Array() {
+					if _, ok := members[r.String()]; !ok {
+						report("missing property \"%s\", required by \"%s\"",
+							r.String(), k.Str)
+					}
+				}
+				return true
+			})
+		}
+		if dep := s.Get("dependentSchemas"); dep.IsObject() {
+			dep.ForEach(func(k, sub gjson.Result) bool {
+				if _, ok := members[k.Str]; ok {
+					out = append(out, v.validate(sub, inst, path, depth)...)
+				}
+				return true
+			})
+		}
+		props := s.Get("properties")
+		patterns := s.Get("patternProperties")
+		additional := s.Get("additionalProperties")
+		names := s.Get("propertyNames")
+		inst.ForEach(func(k, val gjson.Result) bool {
+			if names.Exists() {
+				key := gjson.Parse(string(appendJSONString(nil, k.Str)))
+				if !v.valid(names, key, depth) {
+					report("property name \"%s\" does not match "+
+						"propertyNames", k.Str)
+				}
+			}
+			matched := false
+			if props.IsObject() {
+				if sub := props.Get(escapeKey(k.Str)); sub.Exists() {
+					matched = true
+					out = append(out, v.validate(sub, val, child(k.Str), depth)...)
+				}
+			}
+			if patterns.IsObject() {
+				patterns.ForEach(func(p, sub gjson.Result) bool {
+					if v.matches(p.Str, k.Str) {
+						matched = true
+						out = append(out,
+							v.validate(sub, val, child(k.Str), depth)...)
+					}
+					return true
+				})
+			}
+			if !matched && additional.Exists() {
+				if additional.Type == gjson.False {
+					out = append(out, Violation{child(k.Str),
+						"additional property is not allowed"})
+				} else {
+					out = append(out,
+						v.validate(additional, val, child(k.Str), depth)...)
+				}
+			}
+			return true
+		})
+	}
+	return out
+}
+
+// hasSchemaType reports whether v is of the JSON Schema type name, where an
+// integer is any number without a fractional part, like 1.0.
+func hasSchemaType(v gjson.Result, name string) bool {
+	switch name {
+	case "integer":
+		return v.Type == gjson.Number && v.Float() == math.Trunc(v.Float())
+	case "number":
+		return v.Type == gjson.Number
+	}
+	return schemaType(v) == name
+}
+
+func joinTypes(types []gjson.Result) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return strings.Join(names, " or ")
+}
+
+// isMultipleOf reports whether the number x is a multiple of m. The numbers
+// are divided exactly as they are written, as in floating point 0.3 is not a
+// multiple of 0.1.
+func isMultipleOf(x, m gjson.Result) bool {
+	if rx, ok := exactNumber(x.Raw); ok {
+		if rm, ok := exactNumber(m.Raw); ok && rm.Sign() != 0 {
+			return new(big.Rat).Quo(rx, rm).IsInt()
+		}
+	}
+	q := x.Float() / m.Float()
+	return math.Abs(q-math.Round(q)) <= 1e-9*math.Max(1, math.Abs(q))
+}
+
+// exactNumber returns the JSON number s as a fraction, unless its exponent
+// is so large that the fraction would take too long to compute.
+func exactNumber(s string) (*big.Rat, bool) {
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(strings.TrimPrefix(s[i+1:], "+"))
+		if err != nil || e > 400 || e < -400 {
+			return nil, false
+		}
+	}
+	return new(big.Rat).SetString(s)
+}
@@ -0,0 +1,129 @@
+package jj
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// strictJSON checks that data is a single valid JSON value without
+// duplicate object keys. The error tells the line and column of the first
+// problem, followed by the line of the input where it is.
+func strictJSON(data []byte) error {
+	return strictJSONAt(data, 1)
+}
+
+// strictJSONAt is strictJSON for data that starts at line n of the input,
+// like a line of JSON Lines.
+func strictJSONAt(data []byte, n int) error {
+	var v json.RawMessage
+	if err := json.Unmarshal(data, &v); err != nil {
+		offset := int64(len(data))
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			// the offset is just past the character that failed
+			offset = serr.Offset - 1
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("unexpected end of JSON input")
+		}
+		return positionError(data, n, offset, err.Error())
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if offset, key, ok := findDuplicate(dec); !ok {
+		return positionError(data, n, keyStart(data, offset),
+			fmt.Sprintf("duplicate key \"%s\"", key))
+	}
+	return nil
+}
+
+// keyStart returns the offset of the opening quote of the string that ends
+// just before offset.
+func keyStart(data []byte, offset int64) int64 {
+	for i := offset - 2; i >= 0; i-- {
+		if data[i] != '"' {
+			continue
+		}
+		n := 0
+		for j := i - 1; j >= 0 && data[j] == '\\'; j-- {
+			n++
+		}
+		if n%2 == 0 {
+			return i
+		}
+	}
+	return offset
+}
+
+// findDuplicate reads the next value of dec, and returns the offset just
+// past the first object key that is repeated in the same object, if any.
+func findDuplicate(dec *json.Decoder) (int64, string, bool) {
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, "", true
+	}
+	switch tok {
+	case json.Delim('{'):
+		seen := make(map[string]bool)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return 0, "", true
+			}
+			key, _ := tok.(string)
+			if seen[key] {
+				return dec.InputOffset(), key, false
+			}
+			seen[key] = true
+			if offset, key, ok := findDuplicate(dec); !ok {
+				return offset, key, false
+			}
+		}
+		dec.Token()
+	case json.Delim('['):
+		for dec.More() {
+			if offset, key, ok := findDuplicate(dec); !ok {
+				return offset, key, false
+			}
+		}
+		dec.Token()
+	}
+	return 0, "", true
+}
+
+// positionError describes a problem at the byte offset of data with its
+// line and column, and shows the line with a marker under the column. The
+// lines are counted from first, the line of the input that data starts at.
+func positionError(data []byte, first int, offset int64, msg string) error {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	start := bytes.LastIndexByte(data[:offset], '\n') + 1
+	end := bytes.IndexByte(data[offset:], '\n')
+	if end < 0 {
+		end = len(data)
+	} else {
+		end += int(offset)
+	}
+	line := bytes.Count(data[:start], []byte{'\n'}) + first
+	col := int(offset) - start + 1
+	// keep the context of long lines, like minified JSON, short
+	from, to := start, end
+	if int(offset)-from > 40 {
+		from = int(offset) - 40
+	}
+	if to-int(offset) > 40 {
+		to = int(offset) + 40
+	}
+	context := strings.ReplaceAll(string(data[from:to]), "\t", " ")
+	marker := strings.Repeat(" ", int(offset)-from) + "^"
+	return invalidInput(fmt.Errorf("line %d, column %d: %s\n  %s\n  %s",
+		line, col, msg, strings.TrimRight(context, "\r"), marker))
+}
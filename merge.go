@@ -0,0 +1,174 @@
+package jj
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// mergeObjects deep-merges src into dst. Members that are objects on both
+// sides are merged recursively, any other member of src replaces the one in
+// dst. The members of dst keep their order and new members are appended.
+func mergeObjects(dst, src gjson.Result) []byte {
+	return appendMerged(nil, dst, src)
+}
+
+func appendMerged(buf []byte, dst, src gjson.Result) []byte {
+	srcm := make(map[string]gjson.Result)
+	src.ForEach(func(k, v gjson.Result) bool {
+		srcm[k.String()] = v
+		return true
+	})
+	buf = append(buf, '{')
+	n := 0
+	done := make(map[string]bool)
+	dst.ForEach(func(k, v gjson.Result) bool {
+		key := k.String()
+		if done[key] {
+			return true
+		}
+		done[key] = true
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		n++
+		buf = appendJSONString(buf, key)
+		buf = append(buf, ':')
+		if sv, ok := srcm[key]; !ok {
+			buf = append(buf, v.Raw...)
+		} else if v.IsObject() && sv.IsObject() {
+			buf = appendMerged(buf, v, sv)
+		} else {
+			buf = append(buf, sv.Raw...)
+		}
+		return true
+	})
+	src.ForEach(func(k, v gjson.Result) bool {
+		key := k.String()
+		if done[key] {
+			return true
+		}
+		done[key] = true
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		n++
+		buf = appendJSONString(buf, key)
+		buf = append(buf, ':')
+		buf = append(buf, srcm[key].Raw...)
+		return true
+	})
+	return append(buf, '}')
+}
+
+// splitList splits a comma separated list of keypaths, where "\," is a
+// literal comma.
+func splitList(list string) []string {
+	var items []string
+	start := 0
+	for i := 0; i < len(list); i++ {
+		if list[i] == '\\' {
+			i++
+		} else if list[i] == ',' {
+			items = append(items, list[start:i])
+			start = i + 1
+		}
+	}
+	items = append(items, list[start:])
+	for i := range items {
+		items[i] = strings.ReplaceAll(items[i], "\\,", ",")
+	}
+	return items
+}
+
+// mergeKeys deep-merges, in order, the objects found at paths. Paths that
+// don't exist in the document are skipped.
+func mergeKeys(input []byte, paths []string) ([]byte, error) {
+	merged := gjson.Parse("{}")
+	for _, path := range paths {
+		v := gjson.GetBytes(input, path)
+		if !v.Exists() {
+			continue
+		}
+		if !v.IsObject() {
+			return nil, fmt.Errorf("value at \"%s\" is not an object", path)
+		}
+		merged = gjson.ParseBytes(mergeObjects(merged, v))
+	}
+	return []byte(merged.Raw), nil
+}
+
+// mergeInto returns the object at keypath in json deep-merged with the
+// object value, or value itself when there is nothing at keypath yet.
+func mergeInto(json []byte, keypath, value string) ([]byte, error) {
+	src := gjson.Parse(value)
+	if !gjson.Valid(value) || !src.IsObject() {
+		return nil, fmt.Errorf("value to merge is not a JSON object")
+	}
+	dst := gjson.GetBytes(json, keypath)
+	if !dst.Exists() {
+		return []byte(src.Raw), nil
+	}
+	if !dst.IsObject() {
+		return nil, fmt.Errorf("value to merge into is not a JSON object")
+	}
+	return mergeObjects(dst, src), nil
+}
+
+// MergePatch applies a JSON Merge Patch (RFC 7386) to the JSON document.
+// The members of a patch object are merged recursively into the document,
+// null members delete the member, and any other patch replaces the value.
+func MergePatch(json, patch []byte) ([]byte, error) {
+	if !gjson.ValidBytes(patch) {
+		return nil, fmt.Errorf("JSON merge patch is not valid JSON")
+	}
+	return appendMergePatch(nil, gjson.ParseBytes(json),
+		gjson.ParseBytes(patch)), nil
+}
+
+func appendMergePatch(buf []byte, target, patch gjson.Result) []byte {
+	if !patch.IsObject() {
+		return append(buf, patch.Raw...)
+	}
+	patchm := make(map[string]gjson.Result)
+	patch.ForEach(func(k, v gjson.Result) bool {
+		patchm[k.String()] = v
+		return true
+	})
+	buf = append(buf, '{')
+	n := 0
+	done := make(map[string]bool)
+	member := func(key string, target, patch gjson.Result, patched bool) {
+		if done[key] {
+			return
+		}
+		done[key] = true
+		if patched && patch.Type == gjson.Null {
+			return
+		}
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		n++
+		buf = appendJSONString(buf, key)
+		buf = append(buf, ':')
+		if patched {
+			buf = appendMergePatch(buf, target, patch)
+		} else {
+			buf = append(buf, target.Raw...)
+		}
+	}
+	if target.IsObject() {
+		target.ForEach(func(k, v gjson.Result) bool {
+			pv, ok := patchm[k.String()]
+			member(k.String(), v, pv, ok)
+			return true
+		})
+	}
+	patch.ForEach(func(k, v gjson.Result) bool {
+		member(k.String(), gjson.Result{}, v, true)
+		return true
+	})
+	return append(buf, '}')
+}
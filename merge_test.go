@@ -0,0 +1,72 @@
+package jj
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMergeInto(t *testing.T) {
+	tests := []struct {
+		json, keypath, value string
+		want                 string
+		err                  bool
+	}{
+		{`{"a":{"x":1}}`, "a", `{"y":2}`, `{"x":1,"y":2}`, false},
+		{`{"a":{"x":{"n":1,"m":2}}}`, "a", `{"x":{"n":3}}`,
+			`{"x":{"n":3,"m":2}}`, false},
+		// nothing to merge into yet
+		{`{}`, "a", `{"b":2}`, `{"b":2}`, false},
+		// a member that's a scalar on one side and an object on the other
+		// is replaced by the value's member
+		{`{"a":{"x":1}}`, "a", `{"x":{"n":1}}`, `{"x":{"n":1}}`, false},
+		{`{"a":{"x":{"n":1}}}`, "a", `{"x":2}`, `{"x":2}`, false},
+		{`{"a":{"x":[1,2]}}`, "a", `{"x":{"n":1}}`, `{"x":{"n":1}}`, false},
+		{`{"a":{"x":{"n":1}}}`, "a", `{"x":[1]}`, `{"x":[1]}`, false},
+		// only objects can be merged
+		{`{"a":5}`, "a", `{"x":2}`, "", true},
+		{`{"a":[1]}`, "a", `{"x":2}`, "", true},
+		{`{"a":{}}`, "a", `5`, "", true},
+		{`{"a":{}}`, "a", `[1]`, "", true},
+		{`{"a":{}}`, "a", `{"x":`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := mergeInto([]byte(tt.json), tt.keypath, tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("merging %s into %s at %q: got %s, want an error",
+					tt.value, tt.json, tt.keypath, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("merging %s into %s at %q: %v", tt.value, tt.json,
+				tt.keypath, err)
+		} else if string(got) != tt.want {
+			t.Errorf("merging %s into %s at %q: got %s, want %s", tt.value,
+				tt.json, tt.keypath, got, tt.want)
+		}
+	}
+}
+
+func TestMergeFlag(t *testing.T) {
+	got := runJJ(t, `{"a":{"x":1,"y":{"z":1}},"b":0}`, "-m", "-v",
+		`{"x":{"n":1},"y":{"w":2}}`, "a")
+	want := `{"a":{"x":{"n":1},"y":{"z":1,"w":2}},"b":0}` + "\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMergeFlagConflict(t *testing.T) {
+	for _, input := range []string{`{"a":5}`, `{"a":"x"}`, `{"a":[1]}`} {
+		var out, errOut bytes.Buffer
+		code := Run([]string{"-m", "-v", `{"x":2}`, "a"},
+			strings.NewReader(input), &out, &errOut)
+		if code != exitError || out.Len() != 0 ||
+			!strings.Contains(errOut.String(), "not a JSON object") {
+			t.Errorf("merging into %s: exit status %d, output %q, error %q",
+				input, code, out.String(), errOut.String())
+		}
+	}
+}
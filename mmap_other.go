@@ -0,0 +1,12 @@
+//go:build !unix
+
+package jj
+
+import "os"
+
+// mapFile reads the named file, as files are not mapped into memory on
+// this system.
+func mapFile(name string) ([]byte, func(), error) {
+	data, err := os.ReadFile(name)
+	return data, func() {}, err
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package jj
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the named file into memory read-only, so that its pages are
+// only read as they are used and can be dropped again by the system. The
+// returned function unmaps it. Files that can't be mapped, like pipes, are
+// read instead.
+func mapFile(name string) ([]byte, func(), error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := fi.Size()
+	if fi.Mode().IsRegular() && size > 0 && int64(int(size)) == size {
+		data, err := syscall.Mmap(int(f.Fd()), 0, int(size),
+			syscall.PROT_READ, syscall.MAP_SHARED)
+		if err == nil {
+			return data, func() { syscall.Munmap(data) }, nil
+		}
+	}
+	data, err := io.ReadAll(f)
+	return data, func() {}, err
+}
@@ -0,0 +1,104 @@
+package jj
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// RegisterModifier adds the modifier @name to the keypath syntax, like the
+// built-in @reverse or @join. The function is called with the JSON value the
+// modifier is applied to and the argument after the colon, if any, and
+// returns the new JSON value, or an empty string when there is none.
+// Modifiers must be registered before any other function of the package is
+// used, as they are not safe to change concurrently.
+func RegisterModifier(name string, fn func(json, arg string) string) {
+	gjson.AddModifier(name, fn)
+}
+
+// validModifierName reports whether name can be used as @name in a keypath.
+func validModifierName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// registerModifiers registers the modifiers given with --modifier as
+// name=command.
+func registerModifiers(defs []string, errOut io.Writer) {
+	for _, def := range defs {
+		name, command, _ := strings.Cut(def, "=")
+		RegisterModifier(name, commandModifier(command, errOut))
+	}
+}
+
+// commandModifier returns a modifier that runs command with the shell. The
+// command reads the value from stdin, a string without quotes, and gets the
+// modifier argument in JJ_MODIFIER_ARG. Its output is the new value, which
+// is taken as a string when it's not valid JSON. When the command fails
+// there is no value, and the failure is kept for process to report.
+func commandModifier(command string, errOut io.Writer) func(json, arg string) string {
+	return func(json, arg string) string {
+		input := json
+		if v := gjson.Parse(json); v.Type == gjson.String {
+			input = v.Str
+		}
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", command)
+		} else {
+			cmd = exec.Command("sh", "-c", command)
+		}
+		cmd.Stdin = strings.NewReader(input)
+		cmd.Stderr = errOut
+		cmd.Env = append(os.Environ(), "JJ_MODIFIER_ARG="+arg)
+		out, err := cmd.Output()
+		if err != nil {
+			modifierFailed(fmt.Errorf("modifier command %q: %v", command, err))
+			return ""
+		}
+		out = bytes.TrimSuffix(bytes.TrimSuffix(out, []byte{'\n'}), []byte{'\r'})
+		if gjson.ValidBytes(out) {
+			return string(out)
+		}
+		return string(appendJSONString(nil, string(out)))
+	}
+}
+
+// modifierErr is the first failure of a modifier command since the last call
+// of takeModifierError, as a gjson modifier cannot return an error.
+var modifierErr struct {
+	sync.Mutex
+	err error
+}
+
+// modifierFailed keeps err unless a failure is kept already.
+func modifierFailed(err error) {
+	modifierErr.Lock()
+	defer modifierErr.Unlock()
+	if modifierErr.err == nil {
+		modifierErr.err = err
+	}
+}
+
+// takeModifierError returns the kept failure of a modifier command, if any,
+// and clears it.
+func takeModifierError() error {
+	modifierErr.Lock()
+	defer modifierErr.Unlock()
+	err := modifierErr.err
+	modifierErr.err = nil
+	return err
+}
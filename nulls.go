@@ -0,0 +1,83 @@
+package jj
+
+import (
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// scope returns the value at prefix, or the whole document when prefix is
+// empty, along with the keypath that the value lives at.
+func scope(input []byte, prefix *string) (gjson.Result, string) {
+	if prefix == nil || *prefix == "" {
+		return gjson.ParseBytes(input), ""
+	}
+	return gjson.GetBytes(input, *prefix), *prefix
+}
+
+// replaceNulls sets every null value in the scoped part of the document to
+// val, returning the new document and the number of replacements.
+func replaceNulls(input []byte, prefix *string, val string, raw bool) (
+	[]byte, int, error) {
+	root, path := scope(input, prefix)
+	var paths []string
+	if path != "" && root.Type == gjson.Null && root.Exists() {
+		paths = append(paths, path)
+	}
+	walk(root, path, func(p string, v gjson.Result) bool {
+		if v.Type == gjson.Null {
+			paths = append(paths, p)
+		}
+		return true
+	})
+	out := input
+	var err error
+	for _, p := range paths {
+		if raw {
+			out, err = sjson.SetRawBytes(out, p, []byte(val))
+		} else {
+			out, err = sjson.SetBytes(out, p, val)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return out, len(paths), nil
+}
+
+// omitNulls rebuilds json without the object members that are null, and
+// without null array elements too when arrays is set.
+func omitNulls(json []byte, arrays bool) []byte {
+	return appendOmitNulls(nil, gjson.ParseBytes(json), arrays)
+}
+
+func appendOmitNulls(buf []byte, v gjson.Result, arrays bool) []byte {
+	if !v.IsObject() && !v.IsArray() {
+		return append(buf, v.Raw...)
+	}
+	obj := v.IsObject()
+	if obj {
+		buf = append(buf, '{')
+	} else {
+		buf = append(buf, '[')
+	}
+	n := 0
+	v.ForEach(func(k, e gjson.Result) bool {
+		if e.Type == gjson.Null && (obj || arrays) {
+			return true
+		}
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		if obj {
+			buf = append(buf, k.Raw...)
+			buf = append(buf, ':')
+		}
+		buf = appendOmitNulls(buf, e, arrays)
+		n++
+		return true
+	})
+	if obj {
+		return append(buf, '}')
+	}
+	return append(buf, ']')
+}
@@ -0,0 +1,150 @@
+package jj
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	isatty "github.com/mattn/go-isatty"
+)
+
+// outputFile is where the output is written. When the destination is a
+// regular file the output goes to a temporary file in the same directory,
+// which replaces the destination only once it has been fully written, so
+// that a failed run never leaves a truncated file behind.
+type outputFile struct {
+	io.Writer
+	file *os.File // file opened for -o, nil for the caller's writer
+	path string   // destination to rename to, empty when writing directly
+	done bool
+
+	// backup, when set, is where the file being replaced is copied to
+	// right before it's replaced
+	backup string
+
+	// upload, when set, sends the output written to a URL
+	upload func() error
+}
+
+func createOutput(path string) (*outputFile, error) {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		// replace the file that a symlink points to, not the link
+		path = real
+	}
+	fi, err := os.Stat(path)
+	if err == nil && !fi.Mode().IsRegular() {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return nil, err
+		}
+		return &outputFile{Writer: f, file: f}, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	perm := os.FileMode(0666)
+	if fi != nil {
+		perm = fi.Mode().Perm()
+	}
+	dir, base := filepath.Split(path)
+	for {
+		tmp := filepath.Join(dir, "."+base+".jj"+
+			strconv.FormatUint(rand.Uint64(), 36))
+		f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fi != nil {
+			// keep the exact permissions of the file being replaced,
+			// regardless of the umask, and its owner when possible
+			chownLike(f, fi)
+			if err := f.Chmod(perm); err != nil {
+				f.Close()
+				os.Remove(tmp)
+				return nil, err
+			}
+		}
+		return &outputFile{Writer: f, file: f, path: path}, nil
+	}
+}
+
+// isTerminal reports whether the output is written to a terminal. Writers
+// that are not files are never terminals.
+func (o *outputFile) isTerminal() bool {
+	f, ok := o.Writer.(*os.File)
+	return ok && isatty.IsTerminal(f.Fd())
+}
+
+// Commit closes the output and moves it into place.
+func (o *outputFile) Commit() error {
+	o.done = true
+	if o.upload != nil {
+		return o.upload()
+	}
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	if o.path == "" {
+		return err
+	}
+	if err == nil && o.backup != "" {
+		err = copyFile(o.path, o.backup)
+	}
+	if err == nil {
+		err = os.Rename(o.file.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(o.file.Name())
+	}
+	return err
+}
+
+// Abort discards the output, unless it has already been committed.
+func (o *outputFile) Abort() {
+	if o.done {
+		return
+	}
+	o.done = true
+	if o.file == nil {
+		return
+	}
+	o.file.Close()
+	if o.path != "" {
+		os.Remove(o.file.Name())
+	}
+}
+
+// copyFile copies the contents, permissions, and when possible the owner of
+// src to dst, replacing dst if it already exists.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	chownLike(out, fi)
+	if err := out.Chmod(fi.Mode().Perm()); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
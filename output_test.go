@@ -0,0 +1,89 @@
+package jj
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dirNames returns the names of the files in dir.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestOutputWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.json")
+	if err := os.WriteFile(path, []byte(`{"a":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := createOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(`{"a":`)); err != nil {
+		t.Fatal(err)
+	}
+	// the write fails partway, like on a full disk
+	f.file.Close()
+	if _, err := f.Write([]byte(`2}`)); err == nil {
+		t.Fatal("write to a closed file succeeded")
+	}
+	f.Abort()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"a":1}` {
+		t.Errorf("output file is %q, want it unchanged", data)
+	}
+	if names := dirNames(t, dir); len(names) != 1 || names[0] != "out.json" {
+		t.Errorf("files left in the directory: %q", names)
+	}
+}
+
+func TestOutputCommit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.json")
+	if err := os.WriteFile(path, []byte(`{"a":1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := createOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(`{"a":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	f.Abort()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"a":2}` {
+		t.Errorf("output file is %q, want %q", data, `{"a":2}`)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("output file mode is %v, want %v", fi.Mode().Perm(),
+			os.FileMode(0600))
+	}
+	if names := dirNames(t, dir); len(names) != 1 {
+		t.Errorf("files left in the directory: %q", names)
+	}
+}
@@ -0,0 +1,295 @@
+package jj
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+var (
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+)
+
+// pointer is a parsed JSON Pointer, as used by JSON Patch.
+type pointer []string
+
+func parsePointer(s string) (pointer, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer: \"%s\"", s)
+	}
+	p := pointer(strings.Split(s[1:], "/"))
+	for i := range p {
+		p[i] = pointerUnescaper.Replace(p[i])
+	}
+	return p, nil
+}
+
+func (p pointer) String() string {
+	var sb strings.Builder
+	for _, tok := range p {
+		sb.WriteByte('/')
+		sb.WriteString(pointerEscaper.Replace(tok))
+	}
+	return sb.String()
+}
+
+// keypath returns the gjson/sjson path of the pointer.
+func (p pointer) keypath() string {
+	var path string
+	for _, tok := range p {
+		path = joinPath(path, escapeKey(tok))
+	}
+	return path
+}
+
+func (p pointer) get(json []byte) gjson.Result {
+	if len(p) == 0 {
+		return gjson.ParseBytes(json)
+	}
+	return gjson.GetBytes(json, p.keypath())
+}
+
+func (p pointer) set(json, raw []byte) ([]byte, error) {
+	if len(p) == 0 {
+		return append([]byte(nil), raw...), nil
+	}
+	return sjson.SetRawBytes(json, p.keypath(), raw)
+}
+
+// arrayIndex parses an array index of a pointer, which must be written
+// without leading zeros and be at most n.
+func arrayIndex(tok string, n int) (int, error) {
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || i > n || (len(tok) > 1 && tok[0] == '0') ||
+		tok[0] == '+' {
+		return 0, fmt.Errorf("invalid array index: \"%s\"", tok)
+	}
+	return i, nil
+}
+
+// insertValue returns the array with raw inserted at index i.
+func insertValue(arr gjson.Result, i int, raw []byte) []byte {
+	buf := []byte{'['}
+	n := 0
+	add := func(v []byte) {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, v...)
+		n++
+	}
+	j := 0
+	arr.ForEach(func(_, v gjson.Result) bool {
+		if j == i {
+			add(raw)
+		}
+		add([]byte(v.Raw))
+		j++
+		return true
+	})
+	if i >= j {
+		add(raw)
+	}
+	return append(buf, ']')
+}
+
+func patchAdd(json []byte, p pointer, raw []byte) ([]byte, error) {
+	if len(p) == 0 {
+		return p.set(json, raw)
+	}
+	parent := p[:len(p)-1].get(json)
+	last := p[len(p)-1]
+	switch {
+	case parent.IsArray():
+		n := int(parent.Get("#").Int())
+		i := n
+		if last != "-" {
+			var err error
+			if i, err = arrayIndex(last, n); err != nil {
+				return nil, err
+			}
+		}
+		return p[:len(p)-1].set(json, insertValue(parent, i, raw))
+	case parent.IsObject():
+		return p.set(json, raw)
+	case parent.Exists():
+		return nil, fmt.Errorf("cannot add to a value that is not an "+
+			"object or an array: \"%s\"", p)
+	}
+	return nil, fmt.Errorf("path does not exist: \"%s\"", p[:len(p)-1])
+}
+
+func patchRemove(json []byte, p pointer) ([]byte, error) {
+	if len(p) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	if !p.get(json).Exists() {
+		return nil, fmt.Errorf("path does not exist: \"%s\"", p)
+	}
+	return sjson.DeleteBytes(json, p.keypath())
+}
+
+func applyOperation(json []byte, op gjson.Result) ([]byte, error) {
+	if !op.IsObject() {
+		return nil, errors.New("operation is not an object")
+	}
+	pathv := op.Get("path")
+	if !pathv.Exists() {
+		return nil, errors.New("missing \"path\"")
+	}
+	path, err := parsePointer(pathv.String())
+	if err != nil {
+		return nil, err
+	}
+	value := op.Get("value")
+	name := op.Get("op").String()
+	switch name {
+	case "add", "replace", "test":
+		if !value.Exists() {
+			return nil, fmt.Errorf("missing \"value\" for %s", name)
+		}
+	case "move", "copy":
+		fromv := op.Get("from")
+		if !fromv.Exists() {
+			return nil, fmt.Errorf("missing \"from\" for %s", name)
+		}
+		from, err := parsePointer(fromv.String())
+		if err != nil {
+			return nil, err
+		}
+		value = from.get(json)
+		if !value.Exists() {
+			return nil, fmt.Errorf("path does not exist: \"%s\"", from)
+		}
+		if name == "move" {
+			if from.String() == path.String() {
+				return json, nil
+			}
+			if strings.HasPrefix(path.String(), from.String()+"/") {
+				return nil, fmt.Errorf("cannot move \"%s\" into itself", from)
+			}
+			raw := []byte(value.Raw)
+			if json, err = patchRemove(json, from); err != nil {
+				return nil, err
+			}
+			return patchAdd(json, path, raw)
+		}
+	}
+	switch name {
+	case "add", "copy":
+		return patchAdd(json, path, []byte(value.Raw))
+	case "remove":
+		return patchRemove(json, path)
+	case "replace":
+		if !path.get(json).Exists() {
+			return nil, fmt.Errorf("path does not exist: \"%s\"", path)
+		}
+		return path.set(json, []byte(value.Raw))
+	case "test":
+		if !jsonEqual(path.get(json), value) {
+			return nil, fmt.Errorf("test failed: \"%s\"", path)
+		}
+		return json, nil
+	}
+	return nil, fmt.Errorf("unknown operation: \"%s\"", name)
+}
+
+// ApplyPatch applies a JSON Patch (RFC 6902) to the JSON document. The
+// operations are applied in order, and the first one that fails stops the
+// patch with an error.
+func ApplyPatch(json, patch []byte) ([]byte, error) {
+	if !gjson.ValidBytes(patch) || !gjson.ParseBytes(patch).IsArray() {
+		return nil, errors.New("JSON patch is not a valid JSON array")
+	}
+	var err error
+	for i, op := range gjson.ParseBytes(patch).Array() {
+		json, err = applyOperation(json, op)
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d: %v", i, err)
+		}
+	}
+	return json, nil
+}
+
+// patchWriter builds a JSON Patch document.
+type patchWriter struct {
+	buf []byte
+}
+
+func (w *patchWriter) op(name, path string, value *gjson.Result) {
+	if len(w.buf) == 0 {
+		w.buf = append(w.buf, '[')
+	} else {
+		w.buf = append(w.buf, ',')
+	}
+	w.buf = append(w.buf, `{"op":`...)
+	w.buf = appendJSONString(w.buf, name)
+	w.buf = append(w.buf, `,"path":`...)
+	w.buf = appendJSONString(w.buf, path)
+	if value != nil {
+		w.buf = append(w.buf, `,"value":`...)
+		w.buf = append(w.buf, value.Raw...)
+	}
+	w.buf = append(w.buf, '}')
+}
+
+func (w *patchWriter) diff(a, b gjson.Result, path string) {
+	switch {
+	case a.IsObject() && b.IsObject():
+		am := a.Map()
+		a.ForEach(func(k, av gjson.Result) bool {
+			p := path + "/" + pointerEscaper.Replace(k.String())
+			if bv := b.Get(escapeKey(k.String())); !bv.Exists() {
+				w.op("remove", p, nil)
+			} else {
+				w.diff(av, bv, p)
+			}
+			return true
+		})
+		b.ForEach(func(k, bv gjson.Result) bool {
+			if _, ok := am[k.String()]; !ok {
+				p := path + "/" + pointerEscaper.Replace(k.String())
+				w.op("add", p, &bv)
+			}
+			return true
+		})
+	case a.IsArray() && b.IsArray():
+		aa, ba := a.Array(), b.Array()
+		for i := 0; i < len(aa) && i < len(ba); i++ {
+			w.diff(aa[i], ba[i], path+"/"+strconv.Itoa(i))
+		}
+		for i := len(aa); i < len(ba); i++ {
+			w.op("add", path+"/"+strconv.Itoa(i), &ba[i])
+		}
+		// remove from the end, so that the indexes stay valid
+		for i := len(aa) - 1; i >= len(ba); i-- {
+			w.op("remove", path+"/"+strconv.Itoa(i), nil)
+		}
+	default:
+		if !jsonEqual(a, b) {
+			w.op("replace", path, &b)
+		}
+	}
+}
+
+// Diff returns a JSON Patch (RFC 6902) that turns the JSON document a into
+// the JSON document b.
+func Diff(a, b []byte) ([]byte, error) {
+	if !gjson.ValidBytes(a) || !gjson.ValidBytes(b) {
+		return nil, errors.New("invalid JSON")
+	}
+	var w patchWriter
+	w.diff(gjson.ParseBytes(a), gjson.ParseBytes(b), "")
+	if len(w.buf) == 0 {
+		return []byte("[]"), nil
+	}
+	return append(w.buf, ']'), nil
+}
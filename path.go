@@ -0,0 +1,191 @@
+package jj
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/match"
+)
+
+// escapeKey escapes the characters in an object key that would otherwise be
+// interpreted as gjson/sjson path syntax.
+func escapeKey(key string) string {
+	var esc []byte
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9') || c <= ' ' || c > '~' ||
+			c == '_' || c == '-' || c == ':' {
+			if esc != nil {
+				esc = append(esc, c)
+			}
+			continue
+		}
+		if esc == nil {
+			esc = append(esc, key[:i]...)
+		}
+		esc = append(esc, '\\', c)
+	}
+	if esc == nil {
+		return key
+	}
+	return string(esc)
+}
+
+// literalPath converts a path of plain keys separated by sep to a keypath,
+// escaping the characters of the keys that have a meaning in keypaths.
+func literalPath(path, sep string) string {
+	if path == "" {
+		return path
+	}
+	keys := strings.Split(path, sep)
+	for i, key := range keys {
+		keys[i] = escapeKey(key)
+	}
+	return strings.Join(keys, ".")
+}
+
+// joinPath appends an already escaped component to a keypath.
+func joinPath(path, component string) string {
+	if path == "" {
+		return component
+	}
+	return path + "." + component
+}
+
+// appendJSONString appends s to dst as a quoted JSON string.
+func appendJSONString(dst []byte, s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return append(dst, bytes.TrimRight(buf.Bytes(), "\n")...)
+}
+
+// appendStrings appends strs to dst as a JSON array of strings.
+func appendStrings(dst []byte, strs []string) []byte {
+	dst = append(dst, '[')
+	for i, s := range strs {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, s)
+	}
+	return append(dst, ']')
+}
+
+// getPaths reads the values at the keypaths into an array, with null for
+// the missing values, or into an object keyed by the keypaths that leaves
+// them out. With types, the values are replaced by their type names. It
+// also reports whether any of the values were missing.
+func getPaths(json []byte, paths []string, keyed, types bool) ([]byte, bool) {
+	var missing bool
+	var buf []byte
+	if keyed {
+		buf = append(buf, '{')
+	} else {
+		buf = append(buf, '[')
+	}
+	n := 0
+	for _, path := range paths {
+		v := gjson.GetBytes(json, path)
+		if !v.Exists() {
+			missing = true
+			if keyed {
+				continue
+			}
+		}
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		n++
+		if keyed {
+			buf = appendJSONString(buf, path)
+			buf = append(buf, ':')
+		}
+		if types {
+			buf = appendJSONString(buf, typeName(v))
+		} else if !v.Exists() {
+			buf = append(buf, "null"...)
+		} else {
+			buf = append(buf, v.Raw...)
+		}
+	}
+	if keyed {
+		buf = append(buf, '}')
+	} else {
+		buf = append(buf, ']')
+	}
+	return buf, missing
+}
+
+// isMultiPath reports whether the keypath has a # component, or a key with
+// the * or ? wildcards, which stand for several values to edit.
+func isMultiPath(path string) bool {
+	for _, seg := range splitQuery(path) {
+		if seg.sep == '|' || strings.HasPrefix(seg.text, "#(") ||
+			strings.HasPrefix(seg.text, "@") {
+			// queries and modifiers are left to sjson
+			return false
+		}
+		if seg.text == "#" || hasWildcard(seg.text) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPath returns the keypaths of the values in json matched by a keypath
+// with wildcards, where # stands for every element of an array and a key
+// with * or ? for every matching member. The other components are kept as
+// they are, so that the edit can create them.
+func expandPath(json []byte, path string) []string {
+	var paths []string
+	var expand func(v gjson.Result, prefix string, segs []querySegment)
+	expand = func(v gjson.Result, prefix string, segs []querySegment) {
+		if len(segs) == 0 {
+			paths = append(paths, prefix)
+			return
+		}
+		seg := segs[0].text
+		switch {
+		case seg == "#":
+			if v.IsArray() {
+				for i, e := range v.Array() {
+					expand(e, joinPath(prefix, strconv.Itoa(i)), segs[1:])
+				}
+			}
+		case hasWildcard(seg):
+			i := 0
+			v.ForEach(func(k, e gjson.Result) bool {
+				key := k.String()
+				if v.IsArray() {
+					key = strconv.Itoa(i)
+				}
+				i++
+				if match.Match(key, seg) {
+					expand(e, joinPath(prefix, escapeKey(key)), segs[1:])
+				}
+				return true
+			})
+		default:
+			expand(v.Get(seg), joinPath(prefix, seg), segs[1:])
+		}
+	}
+	expand(gjson.ParseBytes(json), "", splitQuery(path))
+	return paths
+}
+
+// existingPaths returns the keypaths that have a value in json.
+func existingPaths(json []byte, paths []string) []string {
+	var exist []string
+	for _, path := range paths {
+		if gjson.GetBytes(json, path).Exists() {
+			exist = append(exist, path)
+		}
+	}
+	return exist
+}
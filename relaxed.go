@@ -0,0 +1,61 @@
+package jj
+
+// isIdentifier reports whether s can be written as an unquoted JavaScript
+// object key.
+func isIdentifier(s []byte) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || c == '$' || (c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// unquoteKeys removes the quotes around object keys that are valid
+// identifiers, producing JavaScript object literal style output. Line
+// comments, such as those written by --annotate-with, are left untouched.
+func unquoteKeys(json []byte) []byte {
+	out := make([]byte, 0, len(json))
+	for i := 0; i < len(json); i++ {
+		c := json[i]
+		if c == '/' && i+1 < len(json) && json[i+1] == '/' {
+			j := i
+			for j < len(json) && json[j] != '\n' {
+				j++
+			}
+			out = append(out, json[i:j]...)
+			i = j - 1
+			continue
+		}
+		if c != '"' {
+			out = append(out, c)
+			continue
+		}
+		j := i + 1
+		for ; j < len(json) && json[j] != '"'; j++ {
+			if json[j] == '\\' {
+				j++
+			}
+		}
+		if j >= len(json) {
+			return append(out, json[i:]...)
+		}
+		k := j + 1
+		for k < len(json) && (json[k] == ' ' || json[k] == '\t' ||
+			json[k] == '\n' || json[k] == '\r') {
+			k++
+		}
+		if k < len(json) && json[k] == ':' && isIdentifier(json[i+1:j]) {
+			out = append(out, json[i+1:j]...)
+		} else {
+			out = append(out, json[i:j+1]...)
+		}
+		i = j
+	}
+	return out
+}
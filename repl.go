@@ -0,0 +1,261 @@
+package jj
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"golang.org/x/term"
+)
+
+const replHelp = `Each line is a keypath to read, or jj options that read or edit the
+document, like "-v Tom name.first", "-D age", or "--paths". Tab completes
+the keys at the keypath being typed.
+
+  :w [file]  write the document to file, or to the -i file
+  :q         quit, like Ctrl-D
+  :h         show this help
+`
+
+// replErrors is the error output of the lines of the explorer, where the
+// errors in a line are reported without the usage text.
+type replErrors struct{ io.Writer }
+
+func (w replErrors) usageError(msg string) {
+	fmt.Fprintf(w, "error: %s\n", msg)
+}
+
+// openTerminal opens the terminal of the process for -x, which is not stdin
+// when the document is read from it.
+func openTerminal() (io.ReadWriter, int, func(), error) {
+	if f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		return f, int(f.Fd()), func() { f.Close() }, nil
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd())) {
+		rw := struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}
+		return rw, int(os.Stdin.Fd()), func() {}, nil
+	}
+	return nil, 0, nil, errors.New("-x requires a terminal")
+}
+
+// explore reads keypaths and edits from the terminal and applies them to the
+// document, until :q or the end of the input.
+func explore(a args, doc []byte) error {
+	tty, fd, closeTerminal, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer closeTerminal()
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	t := term.NewTerminal(tty, "jj> ")
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		line, pos, choices := completeKeypath(doc, line, pos)
+		if len(choices) > 1 {
+			fmt.Fprintf(t, "%s\n", strings.Join(choices, "  "))
+		}
+		return line, pos, true
+	}
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		words, err := splitWords(line)
+		if err != nil {
+			fmt.Fprintf(t, "error: %v\n", err)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case ":q":
+			return nil
+		case ":h":
+			io.WriteString(t, replHelp)
+			continue
+		case ":w":
+			if err := writeDocument(a, doc, words[1:]); err != nil {
+				fmt.Fprintf(t, "error: %v\n", err)
+			}
+			continue
+		}
+		la, exit, _ := parseArgs(words, t, replErrors{t})
+		if exit {
+			continue
+		}
+		la.style, la.notty = a.style, a.notty || la.notty
+		if !la.ugly {
+			la.pretty = true
+		}
+		if err := readValues(la.edits, strings.NewReader("")); err != nil {
+			fmt.Fprintf(t, "error: %v\n", err)
+			continue
+		}
+		res, err := process(context.Background(), la, doc)
+		if err != nil {
+			fmt.Fprintf(t, "error: %v\n", err)
+			continue
+		}
+		if len(la.edits) > 0 {
+			doc = res.data
+			continue
+		}
+		t.Write(format(la, res, nil, true))
+	}
+}
+
+// writeDocument writes the document for :w to the file in args, or else to
+// the input file, as YAML or TOML when the file extension tells so.
+func writeDocument(a args, doc []byte, args []string) error {
+	var name string
+	switch {
+	case len(args) > 1:
+		return errors.New("too many files")
+	case len(args) == 1:
+		name = args[0]
+	case len(a.infiles) == 1:
+		name = a.infiles[0]
+	default:
+		return errors.New("missing file")
+	}
+	var err error
+	switch formatOf(name) {
+	case "yaml":
+		doc, err = jsonToYAML(doc)
+	case "toml":
+		doc, err = jsonToTOML(doc, a.tomldates)
+	default:
+		doc = append(doc[:len(doc):len(doc)], '\n')
+	}
+	if err != nil {
+		return err
+	}
+	f, err := createOutput(name)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if _, err := f.Write(doc); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// completeKeypath completes the keypath that ends at pos in line with the
+// keys of the document at its parent. When there are several keys that can
+// follow, it completes their common prefix and returns them all.
+func completeKeypath(doc []byte, line string, pos int) (string, int, []string) {
+	start := strings.LastIndexByte(line[:pos], ' ') + 1
+	word := line[start:pos]
+	parent, partial := "", word
+	for i := len(word) - 1; i >= 0; i-- {
+		if word[i] == '.' && (i == 0 || word[i-1] != '\\') {
+			parent, partial = word[:i], word[i+1:]
+			break
+		}
+	}
+	v := gjson.ParseBytes(doc)
+	if parent != "" {
+		v = gjson.GetBytes(doc, parent)
+	}
+	var choices []string
+	i := 0
+	v.ForEach(func(k, _ gjson.Result) bool {
+		key := strconv.Itoa(i)
+		if v.IsObject() {
+			key = escapeKey(k.String())
+		}
+		i++
+		if strings.HasPrefix(key, partial) {
+			choices = append(choices, key)
+		}
+		return true
+	})
+	if len(choices) == 0 {
+		return line, pos, nil
+	}
+	common := choices[0]
+	for _, c := range choices[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	path := common
+	if parent != "" {
+		path = parent + "." + common
+	}
+	if len(choices) == 1 {
+		if c := gjson.GetBytes(doc, path); c.IsObject() || c.IsArray() {
+			path += "."
+		}
+		choices = nil
+	}
+	return line[:start] + path + line[pos:], start + len(path), choices
+}
+
+// splitWords splits a line of the explorer into words like a shell does,
+// with single and double quotes and backslash escapes.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word []byte
+	inWord := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case c == '\\' && i+1 < len(line) && (quote == 0 ||
+			strings.IndexByte(`"\$`, line[i+1]) >= 0):
+			i++
+			word = append(word, line[i])
+			inWord = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, string(word))
+				word, inWord = nil, false
+			}
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
@@ -0,0 +1,134 @@
+package jj
+
+import (
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const draft07 = "http://json-schema.org/draft-07/schema#"
+
+// schemaTypes is the order in which inferred types are written.
+var schemaTypes = []string{
+	"object", "array", "string", "number", "integer", "boolean", "null",
+}
+
+// schemaNode accumulates the shape of every value seen at one keypath.
+type schemaNode struct {
+	types   map[string]bool
+	props   map[string]*schemaNode
+	order   []string
+	seen    map[string]int
+	objects int
+	items   *schemaNode
+}
+
+func schemaType(v gjson.Result) string {
+	switch v.Type {
+	case gjson.Null:
+		return "null"
+	case gjson.True, gjson.False:
+		return "boolean"
+	case gjson.Number:
+		if strings.ContainsAny(v.Raw, ".eE") {
+			return "number"
+		}
+		return "integer"
+	case gjson.String:
+		return "string"
+	}
+	if v.IsArray() {
+		return "array"
+	}
+	return "object"
+}
+
+func (n *schemaNode) merge(v gjson.Result) {
+	if n.types == nil {
+		n.types = make(map[string]bool)
+	}
+	n.types[schemaType(v)] = true
+	if v.IsObject() {
+		if n.props == nil {
+			n.props = make(map[string]*schemaNode)
+			n.seen = make(map[string]int)
+		}
+		n.objects++
+		v.ForEach(func(k, e gjson.Result) bool {
+			key := k.String()
+			p, ok := n.props[key]
+			if !ok {
+				p = &schemaNode{}
+				n.props[key] = p
+				n.order = append(n.order, key)
+			}
+			if n.seen[key] < n.objects {
+				n.seen[key]++
+			}
+			p.merge(e)
+			return true
+		})
+	} else if v.IsArray() {
+		v.ForEach(func(_, e gjson.Result) bool {
+			if n.items == nil {
+				n.items = &schemaNode{}
+			}
+			n.items.merge(e)
+			return true
+		})
+	}
+}
+
+func (n *schemaNode) appendJSON(buf []byte) []byte {
+	var types []string
+	for _, t := range schemaTypes {
+		if n.types[t] && !(t == "integer" && n.types["number"]) {
+			types = append(types, t)
+		}
+	}
+	buf = append(buf, `"type":`...)
+	if len(types) == 1 {
+		buf = appendJSONString(buf, types[0])
+	} else {
+		buf = appendStrings(buf, types)
+	}
+	if n.types["object"] {
+		buf = append(buf, `,"properties":{`...)
+		var required []string
+		for i, key := range n.order {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, key)
+			buf = append(buf, ":{"...)
+			buf = n.props[key].appendJSON(buf)
+			buf = append(buf, '}')
+			if n.seen[key] == n.objects {
+				required = append(required, key)
+			}
+		}
+		buf = append(buf, '}')
+		if len(required) > 0 {
+			buf = append(buf, `,"required":`...)
+			buf = appendStrings(buf, required)
+		}
+	}
+	if n.items != nil {
+		buf = append(buf, `,"items":{`...)
+		buf = n.items.appendJSON(buf)
+		buf = append(buf, '}')
+	}
+	return buf
+}
+
+// inferSchema returns a draft-07 JSON Schema describing v, where array
+// element schemas are merged and object members present in every sampled
+// object are required.
+func inferSchema(v gjson.Result) []byte {
+	var n schemaNode
+	n.merge(v)
+	buf := append([]byte(`{"$schema":`), appendJSONString(nil, draft07)...)
+	buf = append(buf, ',')
+	buf = n.appendJSON(buf)
+	return append(buf, '}')
+}
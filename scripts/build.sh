@@ -43,5 +43,5 @@ fi
 
 
 # build and store objects into original directory.
-go build -ldflags "-X main.version=$VERSION" -o jj cmd/jj/*.go
+go build -ldflags "-X github.com/nuvolaris/jj.version=$VERSION" -o jj ./cmd/jj
 
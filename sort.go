@@ -0,0 +1,124 @@
+package jj
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// typeRank orders values of different JSON types relative to each other.
+// Numeric strings rank with the numbers, so that they sort numerically.
+func typeRank(v gjson.Result) int {
+	switch v.Type {
+	case gjson.Null:
+		return 0
+	case gjson.False:
+		return 1
+	case gjson.True:
+		return 2
+	case gjson.Number:
+		return 3
+	case gjson.String:
+		if _, ok := numericValue(v); ok {
+			return 3
+		}
+		return 4
+	}
+	return 5
+}
+
+// compareValues compares two JSON values, first by the rank of their types,
+// then numerically when they are numbers or numeric strings, otherwise by
+// their string forms. Comparing by rank first keeps the order consistent
+// when an array mixes numbers and strings.
+func compareValues(a, b gjson.Result) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	if ra == 3 {
+		x, _ := numericValue(a)
+		y, _ := numericValue(b)
+		return compareFloats(x, y)
+	}
+	sa, sb := a.String(), b.String()
+	if a.Type == gjson.JSON {
+		sa, sb = a.Raw, b.Raw
+	}
+	switch {
+	case sa < sb:
+		return -1
+	case sa > sb:
+		return 1
+	}
+	return 0
+}
+
+// numericValue returns the value of a number, or of a string that holds a
+// JSON number, like "25".
+func numericValue(v gjson.Result) (float64, bool) {
+	switch v.Type {
+	case gjson.Number:
+		return v.Num, true
+	case gjson.String:
+		if _, ok := scanNumber(v.Str); !ok {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(v.Str, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// sortArray sorts the elements of an array by the value of field, which may
+// be any keypath relative to each element. Elements missing the field are
+// always placed last.
+func sortArray(arr gjson.Result, field string, desc bool) ([]byte, error) {
+	if !arr.IsArray() {
+		return nil, errors.New("value to sort is not an array")
+	}
+	elems := arr.Array()
+	keys := make([]gjson.Result, len(elems))
+	for i, e := range elems {
+		if field == "" {
+			keys[i] = e
+		} else {
+			keys[i] = e.Get(field)
+		}
+	}
+	idx := make([]int, len(elems))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		ki, kj := keys[idx[i]], keys[idx[j]]
+		if !ki.Exists() || !kj.Exists() {
+			return ki.Exists() && !kj.Exists()
+		}
+		c := compareValues(ki, kj)
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	out := []byte{'['}
+	for i, n := range idx {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, elems[n].Raw...)
+	}
+	return append(out, ']'), nil
+}
@@ -0,0 +1,73 @@
+package jj
+
+import (
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// statsTypes are the names of the types counted by --stats, in the order
+// they are written.
+var statsTypes = []string{"object", "array", "string", "number", "true",
+	"false", "null"}
+
+// valueStats returns a summary of the structure of v for --stats: its type
+// and size in bytes, the number of its members or elements, the number of
+// keys in all of its objects, its depth, and how many values of each type
+// it holds, itself included.
+func valueStats(v gjson.Result) []byte {
+	types := map[string]int{}
+	var keys int
+	var walk func(v gjson.Result) int
+	walk = func(v gjson.Result) int {
+		types[typeName(v)]++
+		if !v.IsObject() && !v.IsArray() {
+			return 0
+		}
+		depth := 0
+		v.ForEach(func(_, e gjson.Result) bool {
+			if v.IsObject() {
+				keys++
+			}
+			if d := walk(e); d > depth {
+				depth = d
+			}
+			return true
+		})
+		return depth + 1
+	}
+	var depth, count int
+	if v.Exists() {
+		depth = walk(v)
+		if v.IsObject() || v.IsArray() {
+			v.ForEach(func(_, _ gjson.Result) bool {
+				count++
+				return true
+			})
+		}
+	}
+	out := appendJSONString([]byte(`{"type":`), typeName(v))
+	out = append(out, `,"bytes":`...)
+	out = strconv.AppendInt(out, int64(len(v.Raw)), 10)
+	out = append(out, `,"count":`...)
+	out = strconv.AppendInt(out, int64(count), 10)
+	out = append(out, `,"keys":`...)
+	out = strconv.AppendInt(out, int64(keys), 10)
+	out = append(out, `,"depth":`...)
+	out = strconv.AppendInt(out, int64(depth), 10)
+	out = append(out, `,"types":{`...)
+	first := true
+	for _, t := range statsTypes {
+		if types[t] == 0 {
+			continue
+		}
+		if !first {
+			out = append(out, ',')
+		}
+		first = false
+		out = appendJSONString(out, t)
+		out = append(out, ':')
+		out = strconv.AppendInt(out, int64(types[t]), 10)
+	}
+	return append(out, "}}"...)
+}
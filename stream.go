@@ -0,0 +1,213 @@
+package jj
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/tidwall/gjson"
+)
+
+// openInputs returns a reader over the input files, one after the other,
+// or in when there are none.
+func openInputs(ctx context.Context, a args, in io.Reader) (io.Reader, func(), error) {
+	if len(a.infiles) == 0 {
+		return contextReader{ctx, in}, func() {}, nil
+	}
+	var files []io.ReadCloser
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	var rds []io.Reader
+	for i, name := range a.infiles {
+		f, err := openInput(ctx, a, name)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		if i > 0 {
+			// keep the last line of a file from running into the first
+			// line of the next one
+			rds = append(rds, bytes.NewReader([]byte{'\n'}))
+		}
+		rds = append(rds, f)
+	}
+	return io.MultiReader(rds...), closeAll, nil
+}
+
+// stream writes the results of processing a sequence of documents, one
+// record for each.
+type stream struct {
+	ctx     context.Context
+	a       args
+	w       io.Writer
+	errOut  io.Writer
+	notes   map[string]string
+	tty     bool
+	missing bool // the keypath was missing from at least one document
+}
+
+func newStream(ctx context.Context, a args, w, errOut io.Writer,
+	notes map[string]string) *stream {
+	s := &stream{ctx: ctx, a: a, w: w, errOut: errOut, notes: notes}
+	if f, ok := w.(*outputFile); ok {
+		s.tty = useColor(a, f)
+	}
+	return s
+}
+
+// record processes the document and writes its result on its own line. The
+// label names the document in messages, and err is a failure to read it
+// that is handled like a processing error, and that names the document
+// itself.
+func (s *stream) record(label string, doc []byte, err error) error {
+	var res result
+	readErr := err != nil
+	if err == nil {
+		res, err = process(s.ctx, s.a, doc)
+	}
+	if res.diag != "" {
+		fmt.Fprintf(s.errOut, "%s: %s\n", label, res.diag)
+	}
+	if err != nil {
+		switch s.a.onerror {
+		case "skip":
+			return nil
+		case "empty":
+			res = result{}
+		default:
+			if readErr {
+				return err
+			}
+			return fmt.Errorf("%s: %w", label, err)
+		}
+	}
+	if res.missing && (s.a.exists || s.a.del) {
+		s.missing = true
+		if !s.a.del || s.a.quiet {
+			// keep the output records aligned with the input documents
+			res = result{}
+		}
+	}
+	if res, err = applyTemplate(s.a, res); err != nil {
+		return fmt.Errorf("%s: %w", label, err)
+	}
+	out := format(s.a, res, s.notes, s.tty)
+	if len(out) == 0 && !s.a.join {
+		out = []byte{'\n'}
+	}
+	_, err = s.w.Write(out)
+	return err
+}
+
+// streamLines applies the operation to each line of the input as its own
+// document, writing one output line per input line. Lines are read with a
+// buffer that grows as needed, so there is no limit on their length. With
+// -e or -D, it reports whether the keypath was missing from any of the lines.
+func streamLines(ctx context.Context, a args, in io.Reader, w io.Writer,
+	errOut io.Writer, notes map[string]string) (missing bool, err error) {
+	r, closeInputs, err := openInputs(ctx, a, in)
+	if err != nil {
+		return false, err
+	}
+	defer closeInputs()
+	s := newStream(ctx, a, w, errOut, notes)
+	br := bufio.NewReaderSize(r, 64*1024)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return s.missing, err
+		}
+		line, rerr := br.ReadBytes('\n')
+		if rerr != nil && rerr != io.EOF {
+			return s.missing, rerr
+		}
+		if len(line) == 0 && rerr == io.EOF {
+			return s.missing, nil
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(bytes.TrimSpace(line)) == 0 ||
+			(!a.strict && !gjson.ValidBytes(line)) {
+			_, err = w.Write(append(line, '\n'))
+		} else if a.strict {
+			if err = strictJSONAt(line, n); err == nil {
+				err = s.record(fmt.Sprintf("line %d", n), line, nil)
+			} else {
+				err = s.record(fmt.Sprintf("line %d", n), nil, err)
+			}
+		} else {
+			err = s.record(fmt.Sprintf("line %d", n), line, nil)
+		}
+		if err != nil {
+			return s.missing, err
+		}
+		if rerr == io.EOF {
+			return s.missing, nil
+		}
+	}
+}
+
+// streamDocuments applies the operation to each of the top-level JSON values
+// in the input, which may follow each other without any separator, writing
+// one output line for each. With --concat each input file is read in its own
+// format.
+func streamDocuments(ctx context.Context, a args, in io.Reader, w io.Writer,
+	errOut io.Writer, notes map[string]string) (missing bool, err error) {
+	if a.concat {
+		return concatDocuments(ctx, a, in, w, errOut, notes)
+	}
+	r, closeInputs, err := openInputs(ctx, a, in)
+	if err != nil {
+		return false, err
+	}
+	defer closeInputs()
+	s := newStream(ctx, a, w, errOut, notes)
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return s.missing, err
+		}
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return s.missing, nil
+			}
+			// the rest of the input can't be split into documents
+			return s.missing, decodeError(err, dec.InputOffset())
+		}
+		if err := s.record(fmt.Sprintf("document %d", n), doc, nil); err != nil {
+			return s.missing, err
+		}
+	}
+}
+
+// concatDocuments applies the operation to each of the documents of the
+// input files in turn, or of in when there are no files.
+func concatDocuments(ctx context.Context, a args, in io.Reader, w io.Writer,
+	errOut io.Writer, notes map[string]string) (missing bool, err error) {
+	names := a.infiles
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	s := newStream(ctx, a, w, errOut, notes)
+	n := 0
+	for _, name := range names {
+		docs, err := inputDocuments(ctx, a, in, name)
+		if err != nil {
+			return s.missing, err
+		}
+		for _, doc := range docs {
+			n++
+			if err := s.record(fmt.Sprintf("document %d", n), doc,
+				nil); err != nil {
+				return s.missing, err
+			}
+		}
+	}
+	return s.missing, nil
+}
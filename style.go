@@ -0,0 +1,121 @@
+package jj
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// lightStyle uses darker colors that are readable on a light background.
+var lightStyle = &pretty.Style{
+	Key:    [2]string{"\x1B[34m", "\x1B[0m"},
+	String: [2]string{"\x1B[32m", "\x1B[0m"},
+	Number: [2]string{"\x1B[35m", "\x1B[0m"},
+	True:   [2]string{"\x1B[36m", "\x1B[0m"},
+	False:  [2]string{"\x1B[36m", "\x1B[0m"},
+	Null:   [2]string{"\x1B[31m", "\x1B[0m"},
+	Escape: [2]string{"\x1B[33m", "\x1B[0m"},
+	Append: pretty.TerminalStyle.Append,
+}
+
+// monokaiStyle and solarizedStyle follow the color schemes of the same name,
+// with 256-color codes.
+var monokaiStyle = &pretty.Style{
+	Key:    [2]string{"\x1B[38;5;197m", "\x1B[0m"},
+	String: [2]string{"\x1B[38;5;186m", "\x1B[0m"},
+	Number: [2]string{"\x1B[38;5;141m", "\x1B[0m"},
+	True:   [2]string{"\x1B[38;5;81m", "\x1B[0m"},
+	False:  [2]string{"\x1B[38;5;81m", "\x1B[0m"},
+	Null:   [2]string{"\x1B[38;5;81m", "\x1B[0m"},
+	Escape: [2]string{"\x1B[38;5;208m", "\x1B[0m"},
+	Append: pretty.TerminalStyle.Append,
+}
+
+var solarizedStyle = &pretty.Style{
+	Key:    [2]string{"\x1B[38;5;33m", "\x1B[0m"},
+	String: [2]string{"\x1B[38;5;37m", "\x1B[0m"},
+	Number: [2]string{"\x1B[38;5;125m", "\x1B[0m"},
+	True:   [2]string{"\x1B[38;5;136m", "\x1B[0m"},
+	False:  [2]string{"\x1B[38;5;136m", "\x1B[0m"},
+	Null:   [2]string{"\x1B[38;5;160m", "\x1B[0m"},
+	Escape: [2]string{"\x1B[38;5;61m", "\x1B[0m"},
+	Append: pretty.TerminalStyle.Append,
+}
+
+// parseStyle returns the color style for the JJ_STYLE environment variable,
+// either the name of a preset or a list of SGR codes for each kind of value
+// like "key=34;string=32;number=1;33". A nil style means no color.
+func parseStyle(env string) (*pretty.Style, error) {
+	switch env {
+	case "", "default":
+		return pretty.TerminalStyle, nil
+	case "light":
+		return lightStyle, nil
+	case "monokai":
+		return monokaiStyle, nil
+	case "solarized":
+		return solarizedStyle, nil
+	case "none":
+		return nil, nil
+	}
+	style := *pretty.TerminalStyle
+	var codes *[2]string
+	var more *[2]string // the second color of bool
+	for _, part := range strings.Split(env, ";") {
+		name, code, ok := strings.Cut(part, "=")
+		if !ok {
+			// another parameter of the previous code, like the 33 of "1;33"
+			name, code = "", part
+		}
+		code = strings.TrimSpace(code)
+		if !isSGR(code) {
+			return nil, fmt.Errorf("invalid color code: \"%s\"", code)
+		}
+		if ok {
+			more = nil
+			switch strings.TrimSpace(name) {
+			case "key":
+				codes = &style.Key
+			case "string":
+				codes = &style.String
+			case "number":
+				codes = &style.Number
+			case "true":
+				codes = &style.True
+			case "false":
+				codes = &style.False
+			case "bool":
+				codes, more = &style.True, &style.False
+			case "null":
+				codes = &style.Null
+			case "escape":
+				codes = &style.Escape
+			default:
+				return nil, fmt.Errorf("invalid color name: \"%s\"", name)
+			}
+			codes[0] = "\x1B[" + code + "m"
+		} else if codes == nil {
+			return nil, fmt.Errorf("invalid color: \"%s\"", part)
+		} else {
+			codes[0] = codes[0][:len(codes[0])-1] + ";" + code + "m"
+		}
+		if more != nil {
+			more[0] = codes[0]
+		}
+	}
+	return &style, nil
+}
+
+// isSGR reports whether s is a parameter of an ANSI color escape sequence.
+func isSGR(s string) bool {
+	if s == "" || len(s) > 3 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
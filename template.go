@@ -0,0 +1,114 @@
+package jj
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/tidwall/gjson"
+)
+
+// templateFuncs are the functions of the --template templates, besides the
+// built-in ones of text/template.
+var templateFuncs = template.FuncMap{
+	// join joins the elements of an array with sep, like {{join ", " .tags}}
+	"join": func(sep string, list []interface{}) string {
+		elems := make([]string, len(list))
+		for i, v := range list {
+			elems[i] = templateText(v)
+		}
+		return strings.Join(elems, sep)
+	},
+	// default is def when the value is missing, null, or an empty string,
+	// like {{.nick | default "none"}}
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	// number formats a number with a fmt verb, like {{number "%.2f" .price}},
+	// where %d and the other integer verbs truncate it to an integer
+	"number": func(format string, v interface{}) (string, error) {
+		var f float64
+		var err error
+		switch v := v.(type) {
+		case json.Number:
+			f, err = v.Float64()
+		case string:
+			f, err = json.Number(v).Float64()
+		case float64:
+			f = v
+		default:
+			err = fmt.Errorf("%s is not a number", templateText(v))
+		}
+		if err != nil {
+			return "", err
+		}
+		if format != "" && strings.IndexByte("bcdoxX", format[len(format)-1]) >= 0 {
+			return fmt.Sprintf(format, int64(f)), nil
+		}
+		return fmt.Sprintf(format, f), nil
+	},
+	// json writes the value as JSON, like {{json .address}}
+	"json": templateJSON,
+}
+
+// parseTemplate parses the text of --template.
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("template").Funcs(templateFuncs).Parse(text)
+}
+
+// renderTemplate renders the JSON value with the template, where strings are
+// Go strings, numbers are json.Number, objects are maps, and arrays are
+// slices. With str the value is a string without quotes.
+func renderTemplate(t *template.Template, data []byte, str bool) ([]byte, error) {
+	var v interface{} = string(data)
+	if !str {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// applyTemplate renders the result with the --template of a, if any, which
+// makes it text.
+func applyTemplate(a args, res result) (result, error) {
+	if a.tmpl == nil || len(res.data) == 0 {
+		return res, nil
+	}
+	data, err := renderTemplate(a.tmpl, res.data,
+		!a.raw && res.typ == gjson.String)
+	if err != nil {
+		return res, err
+	}
+	res.data, res.text = data, true
+	return res, nil
+}
+
+// templateText returns a value of a template as text, a string without
+// quotes and anything else as JSON.
+func templateText(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return templateJSON(v)
+}
+
+// templateJSON returns a value of a template as compact JSON.
+func templateJSON(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
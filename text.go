@@ -0,0 +1,90 @@
+package jj
+
+import (
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// normalizeSpace collapses every run of whitespace in s to a single space
+// and trims the ends.
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// normalizeStrings normalizes the whitespace of every string value in the
+// scoped part of the document. Keys and the document structure are not
+// changed.
+func normalizeStrings(input []byte, prefix *string) ([]byte, error) {
+	root, path := scope(input, prefix)
+	type edit struct{ path, val string }
+	var edits []edit
+	if path != "" && root.Type == gjson.String {
+		if s := normalizeSpace(root.Str); s != root.Str {
+			edits = append(edits, edit{path, s})
+		}
+	}
+	walk(root, path, func(p string, v gjson.Result) bool {
+		if v.Type == gjson.String {
+			if s := normalizeSpace(v.Str); s != v.Str {
+				edits = append(edits, edit{p, s})
+			}
+		}
+		return true
+	})
+	out := input
+	var err error
+	for _, e := range edits {
+		out, err = sjson.SetBytes(out, e.path, e.val)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// deepUnstring expands string values that hold an encoded JSON object or
+// array into the structured value, recursively, up to max nested levels of
+// encoding. All other strings are left alone.
+func deepUnstring(v gjson.Result, max int) []byte {
+	return appendUnstring(nil, v, 0, max)
+}
+
+func appendUnstring(buf []byte, v gjson.Result, depth, max int) []byte {
+	switch {
+	case v.Type == gjson.String:
+		if depth < max {
+			s := strings.TrimSpace(v.Str)
+			if len(s) > 0 && (s[0] == '{' || s[0] == '[') && gjson.Valid(s) {
+				return appendUnstring(buf, gjson.Parse(s), depth+1, max)
+			}
+		}
+		return append(buf, v.Raw...)
+	case v.IsObject() || v.IsArray():
+		obj := v.IsObject()
+		if obj {
+			buf = append(buf, '{')
+		} else {
+			buf = append(buf, '[')
+		}
+		n := 0
+		v.ForEach(func(k, e gjson.Result) bool {
+			if n > 0 {
+				buf = append(buf, ',')
+			}
+			if obj {
+				buf = append(buf, k.Raw...)
+				buf = append(buf, ':')
+			}
+			buf = appendUnstring(buf, e, depth, max)
+			n++
+			return true
+		})
+		if obj {
+			return append(buf, '}')
+		}
+		return append(buf, ']')
+	}
+	return append(buf, v.Raw...)
+}
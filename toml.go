@@ -0,0 +1,351 @@
+package jj
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tidwall/gjson"
+)
+
+// tomlToJSON converts a TOML document to JSON, keeping the order of the
+// keys in each table. The dates and times become strings, and their
+// keypaths are returned, so that they are written back as dates and times.
+func tomlToJSON(data []byte) ([]byte, tomlDates, error) {
+	var doc map[string]interface{}
+	md, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the keys of each table in the order they appear in the document,
+	// the tables of an array of tables share the same order
+	order := make(map[string][]string)
+	for _, key := range md.Keys() {
+		parent := strings.Join(key[:len(key)-1], "\x00")
+		order[parent] = append(order[parent], key[len(key)-1])
+	}
+	dates := make(tomlDates)
+	json, err := appendTOMLValue(nil, doc, nil, nil, order, dates)
+	if err != nil {
+		return nil, nil, err
+	}
+	return json, dates, nil
+}
+
+// tomlDates is the set of the values of a TOML document that are dates and
+// times, by their JSON paths with the keys and indexes separated by NULs.
+type tomlDates map[string]bool
+
+func (d tomlDates) has(at []string) bool {
+	return d[strings.Join(at, "\x00")]
+}
+
+// isTOMLDatetime reports whether s can be written as a TOML date or time,
+// like an edit may have left it.
+func isTOMLDatetime(s string) bool {
+	var v map[string]interface{}
+	if strings.ContainsAny(s, "\r\n#") {
+		return false
+	}
+	if _, err := toml.Decode("v = "+s, &v); err != nil || len(v) != 1 {
+		return false
+	}
+	_, ok := v["v"].(time.Time)
+	return ok
+}
+
+// appendTOMLValue appends the TOML value v as JSON. The path has the keys of
+// its table for the key order, and at has the indexes of the arrays too.
+func appendTOMLValue(buf []byte, v interface{}, path, at []string,
+	order map[string][]string, dates tomlDates) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		seen := make(map[string]bool, len(v))
+		for _, k := range order[strings.Join(path, "\x00")] {
+			if _, ok := v[k]; ok && !seen[k] {
+				keys = append(keys, k)
+				seen[k] = true
+			}
+		}
+		var rest []string
+		for k := range v {
+			if !seen[k] {
+				rest = append(rest, k)
+			}
+		}
+		sort.Strings(rest)
+		keys = append(keys, rest...)
+		buf = append(buf, '{')
+		for i, k := range keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, k)
+			buf = append(buf, ':')
+			sub := append(path[:len(path):len(path)], k)
+			subat := append(at[:len(at):len(at)], k)
+			if buf, err = appendTOMLValue(buf, v[k], sub, subat, order,
+				dates); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+	case []map[string]interface{}:
+		buf = append(buf, '[')
+		for i, e := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			subat := append(at[:len(at):len(at)], strconv.Itoa(i))
+			if buf, err = appendTOMLValue(buf, e, path, subat, order,
+				dates); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, e := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			subat := append(at[:len(at):len(at)], strconv.Itoa(i))
+			if buf, err = appendTOMLValue(buf, e, path, subat, order,
+				dates); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	case string:
+		return appendJSONString(buf, v), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("%s: %v cannot be converted to JSON",
+				strings.Join(path, "."), v)
+		}
+		// a float keeps its decimal point, so that it's still a float
+		// when written back to TOML
+		n := len(buf)
+		buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
+		if !strings.ContainsAny(string(buf[n:]), ".e") {
+			buf = append(buf, ".0"...)
+		}
+		return buf, nil
+	case time.Time:
+		layout := time.RFC3339Nano
+		switch v.Location().String() {
+		case "datetime-local":
+			layout = "2006-01-02T15:04:05.999999999"
+		case "date-local":
+			layout = "2006-01-02"
+		case "time-local":
+			layout = "15:04:05.999999999"
+		}
+		dates[strings.Join(at, "\x00")] = true
+		return appendJSONString(buf, v.Format(layout)), nil
+	}
+	return nil, fmt.Errorf("%s: unsupported TOML value", strings.Join(path, "."))
+}
+
+// jsonToTOML converts a JSON object to TOML, keeping the order of the
+// tables. In each table the plain keys are written before the sub-tables,
+// as TOML requires. The strings at the keypaths of dates, as returned by
+// tomlToJSON, are written as TOML dates and times when they still are ones,
+// and all other strings as strings.
+func jsonToTOML(json []byte, dates tomlDates) ([]byte, error) {
+	if !gjson.ValidBytes(json) {
+		return nil, errors.New("invalid JSON")
+	}
+	doc := gjson.ParseBytes(json)
+	if !doc.IsObject() {
+		return nil, errors.New("only an object can be converted to TOML")
+	}
+	return appendTOMLTable(nil, doc, "", nil, dates)
+}
+
+// isTOMLTables reports whether v is written as an array of tables.
+func isTOMLTables(v gjson.Result) bool {
+	if !v.IsArray() {
+		return false
+	}
+	n := 0
+	all := true
+	v.ForEach(func(_, e gjson.Result) bool {
+		n++
+		all = e.IsObject()
+		return all
+	})
+	return n > 0 && all
+}
+
+// onlyTOMLTables reports whether the object t has members and all of them
+// are tables or arrays of tables.
+func onlyTOMLTables(t gjson.Result) bool {
+	n := 0
+	only := true
+	t.ForEach(func(_, v gjson.Result) bool {
+		n++
+		only = v.IsObject() || isTOMLTables(v)
+		return only
+	})
+	return n > 0 && only
+}
+
+// appendTOMLTable appends the object t as the TOML table path, which has the
+// JSON path at.
+func appendTOMLTable(buf []byte, t gjson.Result, path string, at []string,
+	dates tomlDates) ([]byte, error) {
+	var err error
+	t.ForEach(func(k, v gjson.Result) bool {
+		if v.IsObject() || isTOMLTables(v) {
+			return true
+		}
+		buf = appendTOMLKey(buf, k.String())
+		buf = append(buf, " = "...)
+		buf, err = appendTOMLInline(buf, v, append(at[:len(at):len(at)],
+			k.String()), dates)
+		buf = append(buf, '\n')
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.ForEach(func(k, v gjson.Result) bool {
+		name := string(appendTOMLKey([]byte(path), k.String()))
+		sub := append(at[:len(at):len(at)], k.String())
+		switch {
+		case v.IsObject():
+			// a table with only sub-tables needs no header of its own
+			if !onlyTOMLTables(v) {
+				if len(buf) > 0 {
+					buf = append(buf, '\n')
+				}
+				buf = append(buf, '[')
+				buf = append(buf, name...)
+				buf = append(buf, "]\n"...)
+			}
+			buf, err = appendTOMLTable(buf, v, name+".", sub, dates)
+		case isTOMLTables(v):
+			v.ForEach(func(i, e gjson.Result) bool {
+				if len(buf) > 0 {
+					buf = append(buf, '\n')
+				}
+				buf = append(buf, "[["...)
+				buf = append(buf, name...)
+				buf = append(buf, "]]\n"...)
+				buf, err = appendTOMLTable(buf, e, name+".",
+					append(sub[:len(sub):len(sub)], i.String()), dates)
+				return err == nil
+			})
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// appendTOMLInline appends v, which has the JSON path at, as an inline TOML
+// value.
+func appendTOMLInline(buf []byte, v gjson.Result, at []string,
+	dates tomlDates) ([]byte, error) {
+	var err error
+	switch {
+	case v.IsObject():
+		buf = append(buf, '{')
+		n := 0
+		v.ForEach(func(k, v gjson.Result) bool {
+			if n > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, ' ')
+			buf = appendTOMLKey(buf, k.String())
+			buf = append(buf, " = "...)
+			buf, err = appendTOMLInline(buf, v, append(at[:len(at):len(at)],
+				k.String()), dates)
+			n++
+			return err == nil
+		})
+		if n > 0 {
+			buf = append(buf, ' ')
+		}
+		return append(buf, '}'), err
+	case v.IsArray():
+		buf = append(buf, '[')
+		n := 0
+		v.ForEach(func(i, v gjson.Result) bool {
+			if n > 0 {
+				buf = append(buf, ", "...)
+			}
+			buf, err = appendTOMLInline(buf, v, append(at[:len(at):len(at)],
+				i.String()), dates)
+			n++
+			return err == nil
+		})
+		return append(buf, ']'), err
+	}
+	switch v.Type {
+	case gjson.String:
+		if dates.has(at) && isTOMLDatetime(v.Str) {
+			return append(buf, v.Str...), nil
+		}
+		return appendTOMLString(buf, v.Str), nil
+	case gjson.Number, gjson.True, gjson.False:
+		return append(buf, v.Raw...), nil
+	}
+	return nil, errors.New("null cannot be converted to TOML")
+}
+
+func appendTOMLKey(buf []byte, key string) []byte {
+	bare := key != ""
+	for i := 0; i < len(key) && bare; i++ {
+		c := key[i]
+		bare = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '_' || c == '-'
+	}
+	if bare {
+		return append(buf, key...)
+	}
+	return appendTOMLString(buf, key)
+}
+
+func appendTOMLString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf = append(buf, `\"`...)
+		case '\\':
+			buf = append(buf, `\\`...)
+		case '\b':
+			buf = append(buf, `\b`...)
+		case '\t':
+			buf = append(buf, `\t`...)
+		case '\n':
+			buf = append(buf, `\n`...)
+		case '\f':
+			buf = append(buf, `\f`...)
+		case '\r':
+			buf = append(buf, `\r`...)
+		default:
+			if r < 0x20 || r == 0x7f {
+				buf = append(buf, fmt.Sprintf(`\u%04X`, r)...)
+			} else {
+				buf = append(buf, string(r)...)
+			}
+		}
+	}
+	return append(buf, '"')
+}
@@ -0,0 +1,29 @@
+package jj
+
+import "testing"
+
+func TestTOMLDates(t *testing.T) {
+	const doc = "when = 1979-05-27T07:32:00Z\nday = 1979-05-27\nat = 07:32:00\n" +
+		"s = \"1979-05-27\"\nlist = [1979-05-27, \"07:32:00\"]\n"
+	json, dates, err := tomlToJSON([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := jsonToTOML(json, dates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != doc {
+		t.Errorf("got %q, want %q", got, doc)
+	}
+	// without the dates every string is quoted
+	got, err = jsonToTOML(json, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "when = \"1979-05-27T07:32:00Z\"\nday = \"1979-05-27\"\n" +
+		"at = \"07:32:00\"\ns = \"1979-05-27\"\nlist = [\"1979-05-27\", \"07:32:00\"]\n"
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package jj
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tidwall/gjson"
+)
+
+// validJSON checks that data is a single valid JSON value, describing where
+// parsing failed when it is not.
+func validJSON(data []byte) error {
+	if gjson.ValidBytes(data) {
+		return nil
+	}
+	var v json.RawMessage
+	if err := json.Unmarshal(data, &v); err != nil {
+		return decodeError(err, int64(len(data)))
+	}
+	return invalidInput(errors.New("invalid JSON"))
+}
+
+// validateInput checks the input for --validate, each input file on its
+// own, and with -J each of its lines.
+func validateInput(ctx context.Context, a args, in io.Reader) error {
+	if len(a.infiles) == 0 {
+		return validateReader(ctx, a, in)
+	}
+	for _, name := range a.infiles {
+		f, err := openInput(ctx, a, name)
+		if err != nil {
+			return err
+		}
+		err = validateReader(ctx, a, f)
+		f.Close()
+		if err != nil {
+			if len(a.infiles) > 1 {
+				err = fmt.Errorf("%s: %w", name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func validateReader(ctx context.Context, a args, r io.Reader) error {
+	if !a.ndjson {
+		data, err := io.ReadAll(contextReader{ctx, r})
+		if err != nil {
+			return err
+		}
+		if a.strict {
+			return strictJSON(data)
+		}
+		return validJSON(data)
+	}
+	br := bufio.NewReaderSize(r, 64*1024)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, rerr := br.ReadBytes('\n')
+		if rerr != nil && rerr != io.EOF {
+			return rerr
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			if a.strict {
+				if err := strictJSONAt(line, n); err != nil {
+					return err
+				}
+			} else if err := validJSON(line); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+		}
+		if rerr == io.EOF {
+			return nil
+		}
+	}
+}
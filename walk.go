@@ -0,0 +1,154 @@
+package jj
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// walk calls fn with the keypath and value of every member and element
+// beneath v, visiting parents before their children. The walk stops early
+// when fn returns false, in which case walk also returns false.
+func walk(v gjson.Result, path string,
+	fn func(path string, v gjson.Result) bool) bool {
+	if !v.IsObject() && !v.IsArray() {
+		return true
+	}
+	ok := true
+	i := 0
+	v.ForEach(func(k, e gjson.Result) bool {
+		var p string
+		if v.IsObject() {
+			p = joinPath(path, escapeKey(k.String()))
+		} else {
+			p = joinPath(path, strconv.Itoa(i))
+		}
+		i++
+		if !fn(p, e) || !walk(e, p, fn) {
+			ok = false
+		}
+		return ok
+	})
+	return ok
+}
+
+// walkBreadth is like walk but visits every value at one depth before any
+// value at the next depth.
+func walkBreadth(v gjson.Result, path string,
+	fn func(path string, v gjson.Result) bool) bool {
+	type node struct {
+		path string
+		v    gjson.Result
+	}
+	queue := []node{{path, v}}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if !n.v.IsObject() && !n.v.IsArray() {
+			continue
+		}
+		ok := true
+		i := 0
+		n.v.ForEach(func(k, e gjson.Result) bool {
+			var p string
+			if n.v.IsObject() {
+				p = joinPath(n.path, escapeKey(k.String()))
+			} else {
+				p = joinPath(n.path, strconv.Itoa(i))
+			}
+			i++
+			if ok = fn(p, e); ok {
+				queue = append(queue, node{p, e})
+			}
+			return ok
+		})
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// walkOrder walks v depth-first or, when order is "bfs", breadth-first.
+func walkOrder(v gjson.Result, path, order string,
+	fn func(path string, v gjson.Result) bool) bool {
+	if order == "bfs" {
+		return walkBreadth(v, path, fn)
+	}
+	return walk(v, path, fn)
+}
+
+// isLeaf reports whether v has no members or elements to walk into.
+func isLeaf(v gjson.Result) bool {
+	if v.IsObject() || v.IsArray() {
+		empty := true
+		v.ForEach(func(_, _ gjson.Result) bool {
+			empty = false
+			return false
+		})
+		return empty
+	}
+	return true
+}
+
+// leafPaths returns the keypaths of all leaf values beneath v.
+func leafPaths(v gjson.Result, path, order string) []string {
+	var paths []string
+	walkOrder(v, path, order, func(p string, e gjson.Result) bool {
+		if isLeaf(e) {
+			paths = append(paths, p)
+		}
+		return true
+	})
+	return paths
+}
+
+// flatten returns an object with the keypath and value of every leaf value
+// beneath v, in the walk order, where the keypaths are relative to v. Empty
+// objects and arrays are leaves too.
+func flatten(v gjson.Result, order string) ([]byte, error) {
+	if !v.IsObject() && !v.IsArray() {
+		return nil, errors.New("--flatten requires an object or an array")
+	}
+	buf := []byte{'{'}
+	walkOrder(v, "", order, func(p string, e gjson.Result) bool {
+		if isLeaf(e) {
+			if len(buf) > 1 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, p)
+			buf = append(buf, ':')
+			buf = append(buf, e.Raw...)
+		}
+		return true
+	})
+	return append(buf, '}'), nil
+}
+
+// unflatten is the reverse of flatten, setting the value of each member of
+// the object v at its keypath. A document whose first keypath starts with an
+// index is an array.
+func unflatten(v gjson.Result) ([]byte, error) {
+	if !v.IsObject() {
+		return nil, errors.New("--unflatten requires an object")
+	}
+	var buf []byte
+	var err error
+	v.ForEach(func(k, e gjson.Result) bool {
+		buf, err = sjson.SetRawBytes(buf, k.String(), []byte(e.Raw))
+		if err != nil {
+			err = fmt.Errorf("keypath \"%s\": %v", k.String(), err)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		buf = []byte("{}")
+	}
+	return buf, nil
+}
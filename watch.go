@@ -0,0 +1,41 @@
+package jj
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// watchInterval is how often the input file is checked for changes with -w.
+const watchInterval = 250 * time.Millisecond
+
+// watch performs the operation on the input file each time it changes,
+// until the process is interrupted or ctx is done. A failure is reported and
+// watching goes on, as the file may be caught half written.
+func watch(ctx context.Context, a args, in io.Reader, out, errOut io.Writer) (int, error) {
+	var last os.FileInfo
+	for {
+		fi, err := os.Stat(a.infiles[0])
+		if err == nil && (last == nil || !fi.ModTime().Equal(last.ModTime()) ||
+			fi.Size() != last.Size()) {
+			last = fi
+			// the values of -V are read into the edits, which must keep
+			// the file names for the next run
+			ra := a
+			ra.edits = append([]edit(nil), a.edits...)
+			code, err := execute(ctx, ra, in, out, errOut)
+			if ctx.Err() != nil {
+				return timedOut(a)
+			}
+			if err != nil {
+				reportError(errOut, err, code)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return timedOut(a)
+		case <-time.After(watchInterval):
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package jj
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlToJSON converts a YAML document to JSON, keeping the order of the
+// mapping keys. A stream of several documents is an error, as only one
+// could be converted and the others would be lost when the result is
+// written back.
+func yamlToJSON(data []byte) ([]byte, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err == io.EOF {
+		// an empty document
+		return []byte("null"), nil
+	} else if err != nil {
+		return nil, err
+	}
+	for {
+		var next yaml.Node
+		if err := dec.Decode(&next); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		// an empty document, like after a final ---, has nothing to lose
+		if len(next.Content) != 1 || next.Content[0].Tag != "!!null" ||
+			next.Content[0].Value != "" {
+			return nil, fmt.Errorf("line %d: the YAML has more than one "+
+				"document, only a single document can be read", next.Line)
+		}
+	}
+	return appendYAMLNode(nil, &doc)
+}
+
+func appendYAMLNode(buf []byte, n *yaml.Node) ([]byte, error) {
+	var err error
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return appendYAMLNode(buf, n.Content[0])
+	case yaml.AliasNode:
+		return appendYAMLNode(buf, n.Alias)
+	case yaml.MappingNode:
+		buf = append(buf, '{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			key := n.Content[i]
+			if key.Kind == yaml.AliasNode {
+				key = key.Alias
+			}
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: mapping keys must be "+
+					"scalars to convert to JSON", key.Line)
+			}
+			buf = appendJSONString(buf, key.Value)
+			buf = append(buf, ':')
+			if buf, err = appendYAMLNode(buf, n.Content[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+	case yaml.SequenceNode:
+		buf = append(buf, '[')
+		for i, c := range n.Content {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if buf, err = appendYAMLNode(buf, c); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	}
+	switch n.ShortTag() {
+	case "!!null":
+		return append(buf, "null"...), nil
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, err
+		}
+		return strconv.AppendBool(buf, b), nil
+	case "!!int", "!!float":
+		if _, ok := scanNumber(n.Value); ok {
+			return append(buf, n.Value...), nil
+		}
+		// other notations, like 0x1f, 1_000, or .5
+		var i int64
+		if n.Decode(&i) == nil {
+			return strconv.AppendInt(buf, i, 10), nil
+		}
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return nil, err
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("line %d: %s cannot be converted to JSON",
+				n.Line, n.Value)
+		}
+		return strconv.AppendFloat(buf, f, 'g', -1, 64), nil
+	}
+	return appendJSONString(buf, n.Value), nil
+}
+
+// jsonToYAML converts a JSON document to YAML, keeping the order of the
+// object members.
+func jsonToYAML(json []byte) ([]byte, error) {
+	if !gjson.ValidBytes(json) {
+		return nil, errors.New("invalid JSON")
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(jsonToYAMLNode(gjson.ParseBytes(json))); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func jsonToYAMLNode(v gjson.Result) *yaml.Node {
+	switch {
+	case v.IsObject():
+		n := &yaml.Node{Kind: yaml.MappingNode}
+		v.ForEach(func(k, v gjson.Result) bool {
+			n.Content = append(n.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k.String()},
+				jsonToYAMLNode(v))
+			return true
+		})
+		return n
+	case v.IsArray():
+		n := &yaml.Node{Kind: yaml.SequenceNode}
+		v.ForEach(func(_, v gjson.Result) bool {
+			n.Content = append(n.Content, jsonToYAMLNode(v))
+			return true
+		})
+		return n
+	}
+	n := &yaml.Node{Kind: yaml.ScalarNode}
+	switch v.Type {
+	case gjson.String:
+		n.Tag, n.Value = "!!str", v.Str
+	case gjson.Number:
+		n.Tag, n.Value = "!!float", v.Raw
+		if integer, _ := scanNumber(v.Raw); integer {
+			n.Tag = "!!int"
+		}
+	case gjson.True, gjson.False:
+		n.Tag, n.Value = "!!bool", v.Raw
+	default:
+		n.Tag, n.Value = "!!null", "null"
+	}
+	return n
+}
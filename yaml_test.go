@@ -0,0 +1,30 @@
+package jj
+
+import "testing"
+
+func TestYAMLToJSON(t *testing.T) {
+	tests := []struct {
+		yaml, want string
+		err        bool
+	}{
+		{"kind: A\nn: 1\n", `{"kind":"A","n":1}`, false},
+		{"", "null", false},
+		{"---\nkind: A\n", `{"kind":"A"}`, false},
+		{"kind: A\n---\n", `{"kind":"A"}`, false},
+		{"kind: A\n---\nkind: B\n", "", true},
+		{"kind: A\n---\n- 1\n", "", true},
+		{"kind: A\n---\n: [\n", "", true},
+	}
+	for _, tt := range tests {
+		got, err := yamlToJSON([]byte(tt.yaml))
+		if tt.err {
+			if err == nil {
+				t.Errorf("yamlToJSON(%q) = %s, want an error", tt.yaml, got)
+			}
+		} else if err != nil {
+			t.Errorf("yamlToJSON(%q): %v", tt.yaml, err)
+		} else if string(got) != tt.want {
+			t.Errorf("yamlToJSON(%q) = %s, want %s", tt.yaml, got, tt.want)
+		}
+	}
+}